@@ -0,0 +1,350 @@
+package kubby
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"sigs.k8s.io/kind/pkg/apis/config/defaults"
+)
+
+const (
+	ociLayoutFile     = "oci-layout"
+	ociIndexMediaType = "application/vnd.oci.image.index.v1+json"
+	dockerListType    = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Config ociDescriptor   `json:"config"`
+	Layers []ociDescriptor `json:"layers"`
+}
+
+//archiveManifest is an entry of the manifest.json docker load expects at the root of a docker save tarball
+type archiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+//WithAirGap runs without network access to image registries. Images are loaded from the docker save tarballs or
+//OCI layout directories in archives, nothing is pulled, and cluster creation fails listing any image still missing
+func WithAirGap(archives ...string) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.AirGapped = true
+		kc.ImageArchives = append(kc.ImageArchives, archives...)
+	}
+}
+
+//WithNodeImage sets the kind node image, kind's default for its version when empty
+func WithNodeImage(image string) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.NodeImage = image
+	}
+}
+
+//WithOfflineRegistry never pulls the registry image, failing if it isn't present locally
+func WithOfflineRegistry() RegistryOption {
+	return WithRegistryPullPolicy(PullNever)
+}
+
+//prepareAirGap loads the configured archives into the local daemon and checks every image the cluster needs is
+//present before anything tries to pull it
+func (kc *KubeCluster) prepareAirGap(ctx context.Context) error {
+	cli, err := NewContainerClient()
+	if err != nil {
+		return fmt.Errorf("KubeCluster.prepareAirGap: %w", err)
+	}
+
+	err = LoadImageArchives(ctx, cli, kc.ImageArchives...)
+	if err != nil {
+		return fmt.Errorf("KubeCluster.prepareAirGap: %w", err)
+	}
+
+	//loading a tarball drops the repo digest, so kind's digest pinned default would never be found locally
+	if kc.NodeImage == "" {
+		kc.NodeImage = strings.SplitN(defaults.Image, "@", 2)[0]
+	}
+
+	required := []string{kc.NodeImage}
+	if (kc.ImageRegister == nil && !kc.LoadImages) || len(kc.Mirrors) != 0 {
+		required = append(required, "registry:2")
+	}
+	required = append(required, kc.Images...)
+
+	missing, err := missingImages(ctx, cli, required)
+	if err != nil {
+		return fmt.Errorf("KubeCluster.prepareAirGap: %w", err)
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("KubeCluster.prepareAirGap: %w", &MissingImageError{
+			images: missing,
+		})
+	}
+
+	kc.RegistryOptions = append(kc.RegistryOptions, WithOfflineRegistry())
+	for _, mirror := range kc.Mirrors {
+		mirror.Options = append(mirror.Options, WithOfflineRegistry())
+	}
+
+	return nil
+}
+
+//LoadImageArchives loads each path into the docker daemon. A path is either a docker save tarball or a directory
+//in the OCI image layout, which is repackaged on the fly since docker load only understands the former
+func LoadImageArchives(ctx context.Context, cli *client.Client, paths ...string) error {
+	for _, path := range paths {
+		fmt.Printf("loading images from %s...\n", path)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("LoadImageArchives: %w", err)
+		}
+
+		var archive io.ReadCloser
+		if info.IsDir() {
+			archive, err = ociLayoutArchive(path)
+		} else {
+			archive, err = os.Open(path)
+		}
+		if err != nil {
+			return fmt.Errorf("LoadImageArchives: %w", err)
+		}
+
+		err = loadImageArchive(ctx, cli, archive)
+		archive.Close()
+		if err != nil {
+			return fmt.Errorf("LoadImageArchives: %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func loadImageArchive(ctx context.Context, cli *client.Client, archive io.Reader) error {
+	res, err := cli.ImageLoad(ctx, archive, true)
+	if err != nil {
+		return fmt.Errorf("loadImageArchive: %w", err)
+	}
+	defer res.Body.Close()
+
+	err = getDockerOutput(res.Body, nil, nil)
+	if err != nil {
+		return fmt.Errorf("loadImageArchive: %w", err)
+	}
+
+	return nil
+}
+
+//ociLayoutArchive streams dir as a tarball with a docker manifest.json generated from the layout's index
+func ociLayoutArchive(dir string) (io.ReadCloser, error) {
+	_, err := os.Stat(filepath.Join(dir, ociLayoutFile))
+	if err != nil {
+		return nil, fmt.Errorf("ociLayoutArchive: %w", err)
+	}
+
+	index := &ociIndex{}
+	err = readOCIBlob(filepath.Join(dir, "index.json"), index)
+	if err != nil {
+		return nil, fmt.Errorf("ociLayoutArchive: %w", err)
+	}
+
+	manifests := []archiveManifest{}
+	for _, desc := range index.Manifests {
+		ref := desc.Annotations["io.containerd.image.name"]
+		if ref == "" {
+			ref = desc.Annotations["org.opencontainers.image.ref.name"]
+		}
+
+		manifest, err := resolveOCIManifest(dir, desc)
+		if err != nil {
+			return nil, fmt.Errorf("ociLayoutArchive: %w", err)
+		}
+
+		//a bare ref.name is only a tag, which docker can't use without a repository
+		if strings.Contains(ref, ":") || strings.Contains(ref, "/") {
+			manifest.RepoTags = []string{ref}
+		}
+
+		manifests = append(manifests, *manifest)
+	}
+
+	manifestJSON, err := json.Marshal(manifests)
+	if err != nil {
+		return nil, fmt.Errorf("ociLayoutArchive: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(writeOCIArchive(pw, dir, manifestJSON))
+	}()
+
+	return pr, nil
+}
+
+//resolveOCIManifest follows indexes down to the image manifest for the local platform
+func resolveOCIManifest(dir string, desc ociDescriptor) (*archiveManifest, error) {
+	if desc.MediaType == ociIndexMediaType || desc.MediaType == dockerListType {
+		index := &ociIndex{}
+		err := readOCIBlob(filepath.Join(dir, ociBlobPath(desc.Digest)), index)
+		if err != nil {
+			return nil, fmt.Errorf("resolveOCIManifest: %w", err)
+		}
+
+		if len(index.Manifests) == 0 {
+			return nil, fmt.Errorf("resolveOCIManifest: %w", &MissingImageError{
+				images: []string{desc.Digest},
+			})
+		}
+
+		for _, m := range index.Manifests {
+			if m.Platform == nil || m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH {
+				return resolveOCIManifest(dir, m)
+			}
+		}
+
+		return resolveOCIManifest(dir, index.Manifests[0])
+	}
+
+	manifest := &ociManifest{}
+	err := readOCIBlob(filepath.Join(dir, ociBlobPath(desc.Digest)), manifest)
+	if err != nil {
+		return nil, fmt.Errorf("resolveOCIManifest: %w", err)
+	}
+
+	entry := &archiveManifest{
+		Config: ociBlobPath(manifest.Config.Digest),
+	}
+
+	for _, layer := range manifest.Layers {
+		entry.Layers = append(entry.Layers, ociBlobPath(layer.Digest))
+	}
+
+	return entry, nil
+}
+
+func writeOCIArchive(w io.Writer, dir string, manifestJSON []byte) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		//layouts written by docker itself already carry a manifest.json, which is replaced by the generated one
+		if rel == "manifest.json" {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("writeOCIArchive: %w", err)
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:     "manifest.json",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(manifestJSON)),
+	})
+	if err != nil {
+		return fmt.Errorf("writeOCIArchive: %w", err)
+	}
+
+	_, err = tw.Write(manifestJSON)
+	if err != nil {
+		return fmt.Errorf("writeOCIArchive: %w", err)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("writeOCIArchive: %w", err)
+	}
+
+	return nil
+}
+
+func readOCIBlob(path string, v interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("readOCIBlob: %w", err)
+	}
+
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		return fmt.Errorf("readOCIBlob: %w", err)
+	}
+
+	return nil
+}
+
+func ociBlobPath(digest string) string {
+	return filepath.ToSlash(filepath.Join("blobs", strings.Replace(digest, ":", "/", 1)))
+}
+
+//missingImages returns the images in images the local daemon doesn't have
+func missingImages(ctx context.Context, cli *client.Client, images []string) ([]string, error) {
+	missing := []string{}
+
+	for _, image := range images {
+		_, _, err := cli.ImageInspectWithRaw(ctx, image)
+		if err == nil {
+			continue
+		}
+
+		if !client.IsErrNotFound(err) {
+			return nil, fmt.Errorf("missingImages: %w", err)
+		}
+
+		missing = append(missing, image)
+	}
+
+	return missing, nil
+}
@@ -0,0 +1,263 @@
+package kubby
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+//writeTestBlob stores content in the layout's blob store and returns its digest
+func writeTestBlob(t *testing.T, dir string, content []byte) string {
+	t.Helper()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	path := filepath.Join(dir, filepath.FromSlash(ociBlobPath(digest)))
+
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return digest
+}
+
+func writeTestJSONBlob(t *testing.T, dir string, v interface{}) string {
+	t.Helper()
+
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return writeTestBlob(t, dir, content)
+}
+
+//writeTestImage stores a config, one layer and the manifest referencing them, returning the manifest's descriptor
+//and the archive entry expected for it
+func writeTestImage(t *testing.T, dir string, name string) (ociDescriptor, archiveManifest) {
+	t.Helper()
+
+	config := writeTestBlob(t, dir, []byte(fmt.Sprintf(`{"architecture":%q,"os":"linux","name":%q}`, runtime.GOARCH, name)))
+	layer := writeTestBlob(t, dir, []byte(name+" layer"))
+
+	manifest := writeTestJSONBlob(t, dir, ociManifest{
+		Config: ociDescriptor{Digest: config},
+		Layers: []ociDescriptor{{Digest: layer}},
+	})
+
+	return ociDescriptor{MediaType: ociManifestMediaType, Digest: manifest}, archiveManifest{
+		Config: ociBlobPath(config),
+		Layers: []string{ociBlobPath(layer)},
+	}
+}
+
+func TestResolveOCIManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubby-oci-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	native, nativeEntry := writeTestImage(t, dir, "native")
+	other, otherEntry := writeTestImage(t, dir, "other")
+
+	platform := func(desc ociDescriptor, arch string) ociDescriptor {
+		desc.Platform = &struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		}{Architecture: arch, OS: "linux"}
+
+		return desc
+	}
+
+	index := func(manifests ...ociDescriptor) ociDescriptor {
+		digest := writeTestJSONBlob(t, dir, ociIndex{Manifests: manifests})
+
+		return ociDescriptor{MediaType: ociIndexMediaType, Digest: digest}
+	}
+
+	dockerList := index(platform(other, "s390x-test"), platform(native, runtime.GOARCH))
+	dockerList.MediaType = dockerListType
+
+	tests := []struct {
+		name    string
+		desc    ociDescriptor
+		want    archiveManifest
+		missing bool
+	}{
+		{
+			name: "image manifest",
+			desc: native,
+			want: nativeEntry,
+		},
+		{
+			name: "index picks the local platform",
+			desc: index(platform(other, "s390x-test"), platform(native, runtime.GOARCH)),
+			want: nativeEntry,
+		},
+		{
+			name: "docker manifest list picks the local platform",
+			desc: dockerList,
+			want: nativeEntry,
+		},
+		{
+			name: "index without the local platform falls back to the first entry",
+			desc: index(platform(other, "s390x-test"), platform(native, "mips-test")),
+			want: otherEntry,
+		},
+		{
+			name: "nested index",
+			desc: index(index(platform(native, runtime.GOARCH))),
+			want: nativeEntry,
+		},
+		{
+			name:    "empty index",
+			desc:    index(),
+			missing: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := resolveOCIManifest(dir, test.desc)
+
+			if test.missing {
+				missing := &MissingImageError{}
+				if !errors.As(err, &missing) {
+					t.Fatalf("expected a MissingImageError, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("got %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestOCILayoutArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubby-oci-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	named, namedEntry := writeTestImage(t, dir, "named")
+	tagged, taggedEntry := writeTestImage(t, dir, "tagged")
+	bare, bareEntry := writeTestImage(t, dir, "bare")
+
+	named.Annotations = map[string]string{"io.containerd.image.name": "example.com/app:1"}
+	tagged.Annotations = map[string]string{"org.opencontainers.image.ref.name": "example.com/tool:2"}
+	bare.Annotations = map[string]string{"org.opencontainers.image.ref.name": "3"}
+
+	namedEntry.RepoTags = []string{"example.com/app:1"}
+	taggedEntry.RepoTags = []string{"example.com/tool:2"}
+
+	content, err := json.Marshal(ociIndex{Manifests: []ociDescriptor{named, tagged, bare}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		ociLayoutFile: `{"imageLayoutVersion":"1.0.0"}`,
+		"index.json":  string(content),
+		//a manifest.json left by docker save must not end up in the archive next to the generated one
+		"manifest.json": `[{"Config":"stale"}]`,
+	}
+
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive, err := ociLayoutArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	entries := map[string][]byte{}
+	manifestCount := 0
+
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header.Name == "manifest.json" {
+			manifestCount++
+		}
+
+		entries[header.Name] = content
+	}
+
+	if manifestCount != 1 {
+		t.Fatalf("archive holds %d manifest.json entries, want 1", manifestCount)
+	}
+
+	got := []archiveManifest{}
+	err = json.Unmarshal(entries["manifest.json"], &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []archiveManifest{namedEntry, taggedEntry, bareEntry}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest.json is %+v, want %+v", got, want)
+	}
+
+	for _, manifest := range want {
+		for _, path := range append([]string{manifest.Config}, manifest.Layers...) {
+			if _, ok := entries[path]; !ok {
+				t.Errorf("archive is missing %s", path)
+			}
+		}
+	}
+}
+
+func TestOCILayoutArchiveRequiresLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubby-oci-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = ociLayoutArchive(dir)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error for a directory without %s, got %v", ociLayoutFile, err)
+	}
+}
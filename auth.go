@@ -0,0 +1,184 @@
+package kubby
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"golang.org/x/crypto/bcrypt"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	registryAuthPath = "/auth"
+	htpasswdFile     = "htpasswd"
+	PullSecretName   = "kubby-registry"
+)
+
+//WithBasicAuth requires htpasswd authentication for every request to the registry
+func WithBasicAuth(username string, password string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Username = username
+		r.Password = password
+	}
+}
+
+//enableAuth writes an htpasswd file for the registry's credentials and mounts it into the container
+func (r *ClusterRegistry) enableAuth() error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(r.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.enableAuth: %w", err)
+	}
+
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("kubby-%s-auth", r.Name))
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.enableAuth: %w", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, htpasswdFile), []byte(fmt.Sprintf("%s:%s\n", r.Username, hash)), 0644)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.enableAuth: %w", err)
+	}
+
+	r.Mounts = append(r.Mounts, mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   dir,
+		Target:   registryAuthPath,
+		ReadOnly: true,
+	})
+	r.Env = append(r.Env,
+		"REGISTRY_AUTH=htpasswd",
+		"REGISTRY_AUTH_HTPASSWD_REALM=kubby",
+		fmt.Sprintf("REGISTRY_AUTH_HTPASSWD_PATH=%s/%s", registryAuthPath, htpasswdFile),
+	)
+
+	return nil
+}
+
+//registryAuth returns the base64 encoded auth config sent with pushes
+func (r *ClusterRegistry) registryAuth() (string, error) {
+	//the daemon rejects an empty auth header so send a placeholder when the registry is open
+	if r.Username == "" {
+		return "holder", nil
+	}
+
+	encoded, err := json.Marshal(types.AuthConfig{
+		Username:      r.Username,
+		Password:      r.Password,
+		ServerAddress: r.Url,
+	})
+	if err != nil {
+		return "", fmt.Errorf("ClusterRegistry.registryAuth: %w", err)
+	}
+
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
+
+//dockerConfigJSON builds the .dockerconfigjson content for pull secrets. Both the mirrored name used in pod specs
+//and the in network address containerd actually pulls from are included
+func (r *ClusterRegistry) dockerConfigJSON(imagePort string) ([]byte, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", r.Username, r.Password)))
+	entry := map[string]string{
+		"username": r.Username,
+		"password": r.Password,
+		"auth":     auth,
+	}
+
+	networkUrl := fmt.Sprintf("%s:%s", r.Name, imagePort)
+	config := map[string]map[string]map[string]string{
+		"auths": {
+			r.ClusterUrl: entry,
+			networkUrl:   entry,
+		},
+	}
+
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.dockerConfigJSON: %w", err)
+	}
+
+	return content, nil
+}
+
+//ConfigurePullSecret creates a docker-registry secret holding the registry's credentials in namespace and adds it
+//to the namespace's default ServiceAccount so pods pull from the registry without listing imagePullSecrets
+func (kc *KubeCluster) ConfigurePullSecret(ctx context.Context, namespace string) error {
+	registry, ok := kc.ImageRegister.(*ClusterRegistry)
+	if !ok || registry.Username == "" {
+		return nil
+	}
+
+	content, err := registry.dockerConfigJSON(fmt.Sprint(kc.RegistryPort))
+	if err != nil {
+		return fmt.Errorf("KubeCluster.ConfigurePullSecret: %w", err)
+	}
+
+	secrets := kc.KubeClient.CoreV1().Secrets(namespace)
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      PullSecretName,
+			Namespace: namespace,
+		},
+		Type: apiv1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			apiv1.DockerConfigJsonKey: content,
+		},
+	}
+
+	_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("KubeCluster.ConfigurePullSecret: %w", err)
+	}
+
+	accounts := kc.KubeClient.CoreV1().ServiceAccounts(namespace)
+
+	//the default ServiceAccount is created asynchronously after the namespace
+	var account *apiv1.ServiceAccount
+	err = wait.PollImmediateUntil(time.Second/2, func() (bool, error) {
+		sa, err := accounts.Get(ctx, "default", metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		account = sa
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("KubeCluster.ConfigurePullSecret: %w", err)
+	}
+
+	for _, ref := range account.ImagePullSecrets {
+		if ref.Name == PullSecretName {
+			return nil
+		}
+	}
+
+	account.ImagePullSecrets = append(account.ImagePullSecrets, apiv1.LocalObjectReference{
+		Name: PullSecretName,
+	})
+
+	_, err = accounts.Update(ctx, account, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("KubeCluster.ConfigurePullSecret: %w", err)
+	}
+
+	return nil
+}
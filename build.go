@@ -0,0 +1,244 @@
+package kubby
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
+)
+
+type BuildConfig struct {
+	Dockerfile string
+	BuildArgs  map[string]*string
+	Target     string
+	Labels     map[string]string
+	NoCache    bool
+	Pull       bool
+	Platform   string
+	Output     io.Writer
+
+	BuildKit       bool
+	CacheImportDir string
+	CacheExportDir string
+	Secrets        map[string]string
+	SSH            map[string][]string
+}
+
+type BuildOption func(bc *BuildConfig)
+
+//WithDockerfile sets the Dockerfile path, relative to the build context
+func WithDockerfile(path string) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.Dockerfile = path
+	}
+}
+
+func WithBuildArg(key string, value string) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.BuildArgs[key] = &value
+	}
+}
+
+func WithTarget(stage string) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.Target = stage
+	}
+}
+
+func WithBuildLabels(labels map[string]string) BuildOption {
+	return func(bc *BuildConfig) {
+		for k, v := range labels {
+			bc.Labels[k] = v
+		}
+	}
+}
+
+func WithNoCache() BuildOption {
+	return func(bc *BuildConfig) {
+		bc.NoCache = true
+	}
+}
+
+//WithPull always attempts to pull a newer version of the base images
+func WithPull() BuildOption {
+	return func(bc *BuildConfig) {
+		bc.Pull = true
+	}
+}
+
+func WithPlatform(platform string) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.Platform = platform
+	}
+}
+
+//WithBuildOutput streams the build log to w
+func WithBuildOutput(w io.Writer) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.Output = w
+	}
+}
+
+func NewBuildConfig(options ...BuildOption) *BuildConfig {
+	config := &BuildConfig{
+		Dockerfile: "Dockerfile",
+		BuildArgs:  map[string]*string{},
+		Labels:     map[string]string{},
+		Secrets:    map[string]string{},
+		SSH:        map[string][]string{},
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	return config
+}
+
+func buildImage(ctx context.Context, cli *client.Client, path string, image string, options ...BuildOption) error {
+	config := NewBuildConfig(options...)
+
+	if config.CacheImportDir != "" || config.CacheExportDir != "" {
+		err := buildWithBuildkitd(ctx, cli, path, image, config)
+		if err != nil {
+			return fmt.Errorf("buildImage: %w", err)
+		}
+
+		return nil
+	}
+
+	excludes, err := readDockerignore(path, config.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("buildImage: %w", err)
+	}
+
+	tar, err := archive.TarWithOptions(path, &archive.TarOptions{
+		ExcludePatterns: excludes,
+	})
+	if err != nil {
+		return fmt.Errorf("buildImage: %w", err)
+	}
+
+	opts := types.ImageBuildOptions{
+		Dockerfile: filepath.ToSlash(config.Dockerfile),
+		Tags:       []string{image},
+		Remove:     true,
+		BuildArgs:  config.BuildArgs,
+		Target:     config.Target,
+		Labels:     config.Labels,
+		NoCache:    config.NoCache,
+		PullParent: config.Pull,
+		Platform:   config.Platform,
+	}
+
+	if config.BuildKit {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		sess, err := startBuildSession(ctx, cli, path, config)
+		if err != nil {
+			return fmt.Errorf("buildImage: %w", err)
+		}
+		defer sess.Close()
+
+		buildkitOptions(&opts, sess)
+	}
+
+	res, err := cli.ImageBuild(ctx, tar, opts)
+	if err != nil {
+		return fmt.Errorf("buildImage: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	err = getDockerOutput(res.Body, config.Output, nil)
+	if err != nil {
+		return fmt.Errorf("buildImage: %w", err)
+	}
+
+	return nil
+}
+
+//readDockerignore returns the exclude patterns from the context's .dockerignore. The Dockerfile and .dockerignore
+//are never excluded since the daemon needs them to build
+func readDockerignore(contextDir string, dockerfile string) ([]string, error) {
+	f, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("readDockerignore: %w", err)
+	}
+	defer f.Close()
+
+	excludes, err := dockerignore.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("readDockerignore: %w", err)
+	}
+
+	if len(excludes) != 0 {
+		excludes = append(excludes, "!.dockerignore", "!"+filepath.ToSlash(filepath.Clean(dockerfile)))
+	}
+
+	return excludes, nil
+}
+
+//getDockerOutput reads a docker json message stream, writing progress to out and passing aux messages to aux when
+//they are set. The first error message in the stream is returned
+func getDockerOutput(rd io.Reader, out io.Writer, aux func(*json.RawMessage) error) error {
+	decoder := json.NewDecoder(rd)
+
+	for {
+		msg := jsonmessage.JSONMessage{}
+
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("getDockerOutput: %w", err)
+		}
+
+		if msg.Error != nil {
+			return fmt.Errorf("getDockerOutput: %w", &BadImageBuildError{
+				output: msg.Error.Message,
+			})
+		}
+
+		if aux != nil && msg.Aux != nil && msg.ID != buildkitTraceID {
+			err = aux(msg.Aux)
+			if err != nil {
+				return fmt.Errorf("getDockerOutput: %w", err)
+			}
+
+			continue
+		}
+
+		if out != nil && msg.ID == buildkitTraceID {
+			err = displayBuildkitTrace(&msg, out)
+			if err != nil {
+				return fmt.Errorf("getDockerOutput: %w", err)
+			}
+
+			continue
+		}
+
+		if out != nil {
+			err = msg.Display(out, false)
+			if err != nil {
+				return fmt.Errorf("getDockerOutput: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package kubby
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
+)
+
+const buildkitTraceID = "moby.buildkit.trace"
+
+//WithBuildKit builds with the daemon's BuildKit builder instead of the classic builder
+func WithBuildKit() BuildOption {
+	return func(bc *BuildConfig) {
+		bc.BuildKit = true
+	}
+}
+
+//WithCacheImport seeds the build cache from a local cache directory written by WithCacheExport. Builds with a cache
+//directory run on a buildkitd container since the daemon's own BuildKit can't export local cache
+func WithCacheImport(dir string) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.BuildKit = true
+		bc.CacheImportDir = dir
+	}
+}
+
+//WithCacheExport writes the build cache of every stage, intermediate stages of multi-stage builds included, to dir
+//in the local cache format so later builds can import it with WithCacheImport
+func WithCacheExport(dir string) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.BuildKit = true
+		bc.CacheExportDir = dir
+	}
+}
+
+//WithSecret exposes the file at path to RUN --mount=type=secret,id=<id> instructions
+func WithSecret(id string, path string) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.BuildKit = true
+		bc.Secrets[id] = path
+	}
+}
+
+//WithSSH forwards an ssh agent socket or keys to RUN --mount=type=ssh,id=<id> instructions. SSH_AUTH_SOCK is used
+//when no paths are given
+func WithSSH(id string, paths ...string) BuildOption {
+	return func(bc *BuildConfig) {
+		bc.BuildKit = true
+		bc.SSH[id] = paths
+	}
+}
+
+//startBuildSession opens a BuildKit session on the daemon carrying the configured secrets and ssh agents
+func startBuildSession(ctx context.Context, cli *client.Client, contextDir string, config *BuildConfig) (*session.Session, error) {
+	sess, err := session.NewSession(ctx, filepath.Base(contextDir), contextDir)
+	if err != nil {
+		return nil, fmt.Errorf("startBuildSession: %w", err)
+	}
+
+	attachables, err := sessionAttachables(config)
+	if err != nil {
+		return nil, fmt.Errorf("startBuildSession: %w", err)
+	}
+
+	for _, a := range attachables {
+		sess.Allow(a)
+	}
+
+	go func() {
+		err := sess.Run(ctx, func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+			return cli.DialHijack(ctx, "/session", proto, meta)
+		})
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("build session ended: %v\n", err)
+		}
+	}()
+
+	return sess, nil
+}
+
+//sessionAttachables provides the configured secrets and ssh agents to a build session
+func sessionAttachables(config *BuildConfig) ([]session.Attachable, error) {
+	attachables := []session.Attachable{}
+
+	if len(config.Secrets) != 0 {
+		sources := []secretsprovider.Source{}
+		for id, path := range config.Secrets {
+			sources = append(sources, secretsprovider.Source{
+				ID:       id,
+				FilePath: path,
+			})
+		}
+
+		store, err := secretsprovider.NewStore(sources)
+		if err != nil {
+			return nil, fmt.Errorf("sessionAttachables: %w", err)
+		}
+
+		attachables = append(attachables, secretsprovider.NewSecretProvider(store))
+	}
+
+	if len(config.SSH) != 0 {
+		agents := []sshprovider.AgentConfig{}
+		for id, paths := range config.SSH {
+			agents = append(agents, sshprovider.AgentConfig{
+				ID:    id,
+				Paths: paths,
+			})
+		}
+
+		provider, err := sshprovider.NewSSHAgentProvider(agents)
+		if err != nil {
+			return nil, fmt.Errorf("sessionAttachables: %w", err)
+		}
+
+		attachables = append(attachables, provider)
+	}
+
+	return attachables, nil
+}
+
+//displayBuildkitTrace writes the vertex names and logs carried by a BuildKit trace message to out
+func displayBuildkitTrace(msg *jsonmessage.JSONMessage, out io.Writer) error {
+	if msg.Aux == nil {
+		return nil
+	}
+
+	var data []byte
+	err := json.Unmarshal(*msg.Aux, &data)
+	if err != nil {
+		return fmt.Errorf("displayBuildkitTrace: %w", err)
+	}
+
+	status := &controlapi.StatusResponse{}
+	err = status.Unmarshal(data)
+	if err != nil {
+		return fmt.Errorf("displayBuildkitTrace: %w", err)
+	}
+
+	for _, v := range status.Vertexes {
+		displayVertex(out, v.Name, v.Error, v.Cached, v.Started, v.Completed)
+	}
+
+	for _, l := range status.Logs {
+		_, err = out.Write(l.Msg)
+		if err != nil {
+			return fmt.Errorf("displayBuildkitTrace: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func displayVertex(out io.Writer, name string, vertexErr string, cached bool, started *time.Time, completed *time.Time) {
+	switch {
+	case vertexErr != "":
+		fmt.Fprintf(out, "%s ERROR: %s\n", name, vertexErr)
+	case cached && completed != nil:
+		fmt.Fprintf(out, "%s CACHED\n", name)
+	case completed != nil && started != nil:
+		fmt.Fprintf(out, "%s DONE %.1fs\n", name, completed.Sub(*started).Seconds())
+	case started != nil:
+		fmt.Fprintf(out, "%s\n", name)
+	}
+}
+
+func buildkitOptions(opts *types.ImageBuildOptions, sess *session.Session) {
+	opts.Version = types.BuilderBuildKit
+	opts.SessionID = sess.ID()
+}
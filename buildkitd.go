@@ -0,0 +1,287 @@
+package kubby
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	bkclient "github.com/moby/buildkit/client"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	buildkitdName   = "kubby-buildkit"
+	buildkitdImage  = "moby/buildkit"
+	buildkitdTag    = "v0.8.3"
+	buildkitdState  = "/var/lib/buildkit"
+	buildkitdReady  = time.Second * 30
+	dockerfileFront = "dockerfile.v0"
+)
+
+//execConn is a connection to the stdin and stdout of a process exec'd in a container. Stdout arrives multiplexed
+//with stderr, so it is demultiplexed into a pipe that reads are served from
+type execConn struct {
+	net.Conn
+	stdout *io.PipeReader
+	attach types.HijackedResponse
+}
+
+func (conn *execConn) Read(p []byte) (int, error) {
+	return conn.stdout.Read(p)
+}
+
+func (conn *execConn) Close() error {
+	conn.stdout.Close()
+	conn.attach.Close()
+
+	return nil
+}
+
+//buildWithBuildkitd builds contextDir on the kubby-buildkit container with the local cache directories configured
+//and loads the result into the daemon as image
+func buildWithBuildkitd(ctx context.Context, cli *client.Client, contextDir string, image string, config *BuildConfig) error {
+	builder, err := startBuildkitd(ctx, cli)
+	if err != nil {
+		return fmt.Errorf("buildWithBuildkitd: %w", err)
+	}
+
+	bk, err := bkclient.New(ctx, "", bkclient.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		//the dial context ends once the connection is up, the build's context outlives it
+		return dialStdio(ctx, cli, builder.Id)
+	}))
+	if err != nil {
+		return fmt.Errorf("buildWithBuildkitd: %w", err)
+	}
+	defer bk.Close()
+
+	attachables, err := sessionAttachables(config)
+	if err != nil {
+		return fmt.Errorf("buildWithBuildkitd: %w", err)
+	}
+
+	contextDir, err = filepath.Abs(contextDir)
+	if err != nil {
+		return fmt.Errorf("buildWithBuildkitd: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+
+	opt := bkclient.SolveOpt{
+		Frontend:      dockerfileFront,
+		FrontendAttrs: frontendAttrs(config),
+		LocalDirs: map[string]string{
+			"context":    contextDir,
+			"dockerfile": filepath.Dir(filepath.Join(contextDir, config.Dockerfile)),
+		},
+		Session: attachables,
+		Exports: []bkclient.ExportEntry{{
+			Type: bkclient.ExporterDocker,
+			Attrs: map[string]string{
+				"name": image,
+			},
+			Output: func(map[string]string) (io.WriteCloser, error) {
+				return pw, nil
+			},
+		}},
+	}
+
+	if config.CacheImportDir != "" {
+		opt.CacheImports = []bkclient.CacheOptionsEntry{{
+			Type: "local",
+			Attrs: map[string]string{
+				"src": config.CacheImportDir,
+			},
+		}}
+	}
+
+	if config.CacheExportDir != "" {
+		opt.CacheExports = []bkclient.CacheOptionsEntry{{
+			Type: "local",
+			Attrs: map[string]string{
+				"dest": config.CacheExportDir,
+				"mode": "max",
+			},
+		}}
+	}
+
+	loaded := make(chan error, 1)
+	go func() {
+		err := loadImageArchive(ctx, cli, pr)
+		pr.CloseWithError(err)
+		loaded <- err
+	}()
+
+	status := make(chan *bkclient.SolveStatus)
+	displayed := make(chan struct{})
+	go func() {
+		displaySolveStatus(status, config.Output)
+		close(displayed)
+	}()
+
+	_, err = bk.Solve(ctx, nil, opt, status)
+	<-displayed
+	pw.CloseWithError(err)
+	loadErr := <-loaded
+	if err != nil {
+		return fmt.Errorf("buildWithBuildkitd: %w", err)
+	}
+	if loadErr != nil {
+		return fmt.Errorf("buildWithBuildkitd: %w", loadErr)
+	}
+
+	return nil
+}
+
+//frontendAttrs translates the build config into dockerfile frontend options
+func frontendAttrs(config *BuildConfig) map[string]string {
+	attrs := map[string]string{
+		"filename": filepath.Base(config.Dockerfile),
+	}
+
+	if config.Target != "" {
+		attrs["target"] = config.Target
+	}
+
+	if config.Platform != "" {
+		attrs["platform"] = config.Platform
+	}
+
+	if config.NoCache {
+		attrs["no-cache"] = ""
+	}
+
+	if config.Pull {
+		attrs["image-resolve-mode"] = "pull"
+	}
+
+	for k, v := range config.BuildArgs {
+		if v != nil {
+			attrs["build-arg:"+k] = *v
+		}
+	}
+
+	for k, v := range config.Labels {
+		attrs["label:"+k] = v
+	}
+
+	return attrs
+}
+
+//startBuildkitd starts, or reuses, the privileged buildkitd container local cache builds run on. Its state is kept
+//in a volume so the builder's own cache survives the container being removed
+func startBuildkitd(ctx context.Context, cli *client.Client) (*Container, error) {
+	builder := &Container{
+		Client:     cli,
+		Name:       buildkitdName,
+		Image:      buildkitdImage,
+		Tag:        buildkitdTag,
+		Privileged: true,
+		Pull:       PullIfNotPresent,
+		Labels: map[string]string{
+			managedLabel: "true",
+		},
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: fmt.Sprintf("%s-state", buildkitdName),
+			Target: buildkitdState,
+		}},
+	}
+
+	existing, err := findContainerByName(ctx, cli, buildkitdName)
+	if err != nil {
+		return nil, fmt.Errorf("startBuildkitd: %w", err)
+	}
+
+	if existing == nil {
+		err = builder.Start(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("startBuildkitd: %w", err)
+		}
+	} else {
+		if existing.Labels[managedLabel] != "true" {
+			return nil, fmt.Errorf("startBuildkitd: %w", &ForeignContainerError{
+				name: buildkitdName,
+			})
+		}
+
+		builder.Id = existing.ID
+
+		if existing.State != "running" {
+			err = cli.ContainerStart(ctx, builder.Id, types.ContainerStartOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("startBuildkitd: %w", err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, buildkitdReady)
+	defer cancel()
+
+	err = wait.PollImmediateUntil(time.Second/2, func() (bool, error) {
+		code, _, err := builder.Exec(ctx, "buildctl", "debug", "workers")
+		return err == nil && code == 0, nil
+	}, ctx.Done())
+	if err != nil {
+		return nil, fmt.Errorf("startBuildkitd: %w", &ContainerNotReadyError{
+			name:   buildkitdName,
+			reason: "buildctl debug workers did not succeed",
+		})
+	}
+
+	return builder, nil
+}
+
+//dialStdio connects to buildkitd through buildctl dial-stdio, so the daemon doesn't need to listen on a port
+func dialStdio(ctx context.Context, cli *client.Client, id string) (net.Conn, error) {
+	exec, err := cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:          []string{"buildctl", "dial-stdio"},
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dialStdio: %w", err)
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("dialStdio: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, ioutil.Discard, attach.Reader)
+		pw.CloseWithError(err)
+	}()
+
+	return &execConn{
+		Conn:   attach.Conn,
+		stdout: pr,
+		attach: attach,
+	}, nil
+}
+
+//displaySolveStatus writes vertex progress and logs to out until status is closed, draining it when out is nil
+func displaySolveStatus(status chan *bkclient.SolveStatus, out io.Writer) {
+	for s := range status {
+		if out == nil {
+			continue
+		}
+
+		for _, v := range s.Vertexes {
+			displayVertex(out, v.Name, v.Error, v.Cached, v.Started, v.Completed)
+		}
+
+		for _, l := range s.Logs {
+			out.Write(l.Data)
+		}
+	}
+}
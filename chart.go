@@ -33,11 +33,7 @@ func NewHelmChartManager(path string) (*HelmChartManager, error) {
 
 func (hcm *HelmChartManager) InstallChart(name string, namespace string, path string) error {
 	fmt.Printf("installing %s chart ...\n", name)
-	actionConfig := new(action.Configuration)
-	err := actionConfig.Init(kube.GetConfig(hcm.KubeConfigPath, "", namespace), namespace, os.Getenv("HELM_DRIVER"), func(format string, v ...interface{}) {
-		fmt.Printf(format, v)
-		fmt.Println()
-	})
+	actionConfig, err := newActionConfig(hcm.KubeConfigPath, namespace)
 	if err != nil {
 		return fmt.Errorf("InstallChart: %w", err)
 	}
@@ -64,3 +60,16 @@ func (hcm *HelmChartManager) InstallChart(name string, namespace string, path st
 
 	return nil
 }
+
+func newActionConfig(kubePath string, namespace string) (*action.Configuration, error) {
+	actionConfig := new(action.Configuration)
+	err := actionConfig.Init(kube.GetConfig(kubePath, "", namespace), namespace, os.Getenv("HELM_DRIVER"), func(format string, v ...interface{}) {
+		fmt.Printf(format, v...)
+		fmt.Println()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("newActionConfig: %w", err)
+	}
+
+	return actionConfig, nil
+}
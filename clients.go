@@ -0,0 +1,89 @@
+package kubby
+
+import (
+	"fmt"
+	"sync"
+
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//KubeClients holds every client kubby uses to talk to a cluster. They are all built from the same rest.Config
+//and share a single discovery backed RESTMapper
+type KubeClients struct {
+	RestConfig       *rest.Config
+	Client           *kubernetes.Clientset
+	DynamicClient    dynamic.Interface
+	ExtensionsClient *apiextensionsclient.Clientset
+	Mapper           *restmapper.DeferredDiscoveryRESTMapper
+	Scheme           *runtime.Scheme
+	runtimeClient    client.Client
+	runtimeErr       error
+	runtimeOnce      sync.Once
+}
+
+func NewKubeClients(config *rest.Config) (*KubeClients, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("NewKubeClients: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("NewKubeClients: %w", err)
+	}
+
+	extensionsClient, err := apiextensionsclient.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("NewKubeClients: %w", err)
+	}
+
+	clients := &KubeClients{
+		RestConfig:       config,
+		Client:           clientset,
+		DynamicClient:    dynamicClient,
+		ExtensionsClient: extensionsClient,
+		Mapper:           restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery())),
+		Scheme:           clientgoscheme.Scheme,
+	}
+
+	return clients, nil
+}
+
+func NewKubeClientsFromPath(path string) (*KubeClients, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		return nil, fmt.Errorf("NewKubeClientsFromPath: %w", err)
+	}
+
+	clients, err := NewKubeClients(config)
+	if err != nil {
+		return nil, fmt.Errorf("NewKubeClientsFromPath: %w", err)
+	}
+
+	return clients, nil
+}
+
+//RuntimeClient returns a controller-runtime client using Scheme and the shared RESTMapper. It is created on first
+//use and reused afterwards, it is safe to call concurrently
+func (clients *KubeClients) RuntimeClient() (client.Client, error) {
+	clients.runtimeOnce.Do(func() {
+		clients.runtimeClient, clients.runtimeErr = client.New(clients.RestConfig, client.Options{
+			Scheme: clients.Scheme,
+			Mapper: clients.Mapper,
+		})
+	})
+	if clients.runtimeErr != nil {
+		return nil, fmt.Errorf("KubeClients.RuntimeClient: %w", clients.runtimeErr)
+	}
+
+	return clients.runtimeClient, nil
+}
@@ -10,8 +10,14 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/kind/pkg/cluster"
 	kindcmd "sigs.k8s.io/kind/pkg/cmd"
 )
@@ -20,12 +26,19 @@ type KubeResourcer interface {
 	RunJob(context.Context, string, *batchv1.Job, time.Duration) error
 	CreateDeployment(context.Context, string, *appsv1.Deployment) error
 	DeleteDeployment(context.Context, string, string) error
-	CreateNamespace(ctx context.Context, name string) error
+	CreateNamespace(context.Context, string, ...NamespaceOption) error
+	DeleteNamespace(context.Context, string, bool) error
+	InstallCRDs(context.Context, ...string) error
+	CreateCustomResource(context.Context, *unstructured.Unstructured) (*unstructured.Unstructured, error)
+	GetCustomResource(context.Context, schema.GroupVersionKind, string, string) (*unstructured.Unstructured, error)
+	WaitForCustomResource(context.Context, schema.GroupVersionKind, string, string, time.Duration, CustomResourceCondition) error
 }
 
 type ImageRegister interface {
-	BuildAndPushImage(context.Context, string, string) error
-	PushImage(context.Context, string) error
+	BuildAndPushImage(context.Context, string, string, ...BuildOption) (*PushedImage, error)
+	PushImage(context.Context, string) (*PushedImage, error)
+	Acquire(context.Context, string) error
+	Release(context.Context, string) error
 	Delete(context.Context) error
 }
 
@@ -44,11 +57,26 @@ type KubeCluster struct {
 	Status           ClusterStatus
 	RegistryName     string
 	KubeClient       *kubernetes.Clientset
+	Clients          *KubeClients
+	Scheme           *runtime.Scheme
 	RegistryPort     int
+	RegistryCertDir  string
+	RegistryOptions  []RegistryOption
+	Network          string
+	Mirrors          []*MirrorConfig
+	MirrorRegistries []*ClusterRegistry
 	NodePorts        []*NodePort
 	Namespaces       []string
+	NamespaceOptions []NamespaceOption
 	Charts           []*HelmChart
 	Images           []string
+	LoadImages       bool
+	AirGapped        bool
+	ImageArchives    []string
+	NodeImage        string
+	DiagnoseFailures bool
+	DiagnosticsDir   string
+	LastDiagnostics  *DiagnosticBundle
 	KubeResourcer
 	HelmResourcer
 	ImageRegister
@@ -104,18 +132,80 @@ func WithRegistry(registry *ClusterRegistry, port int) KubeClusterOption {
 	}
 }
 
+//WithNodeImageLoading loads images directly into the kind nodes instead of running a registry, see NodeImageLoader
+func WithNodeImageLoading() KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.LoadImages = true
+	}
+}
+
+//WithRegistryOptions sets the options used when the cluster creates its own registry
+func WithRegistryOptions(options ...RegistryOption) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.RegistryOptions = append(kc.RegistryOptions, options...)
+	}
+}
+
+//WithRegistryTLS serves the cluster's registry over https with certificates kept in certDir and configures the kind
+//nodes to trust its CA
+func WithRegistryTLS(certDir string) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.RegistryCertDir = certDir
+	}
+}
+
+//WithRegistryMirror starts a pull through cache of upstream on port and registers it with containerd as the mirror
+//for images hosted on host, e.g. docker.io. Upstream can be any registry reachable from the kind network, including
+//another kubby registry such as http://kind-registry:5000
+func WithRegistryMirror(host string, upstream string, port int, options ...RegistryOption) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.Mirrors = append(kc.Mirrors, &MirrorConfig{
+			Host:     host,
+			Upstream: upstream,
+			Name:     mirrorName(host),
+			Port:     port,
+			Options:  options,
+		})
+	}
+}
+
+//WithKubeClient sets the clientset used for KubeClient
+//
+//Deprecated: only KubeClient uses the given clientset, every other client is still built from the kubeconfig so the
+//two can point at different clusters. Use WithKubeClients to share a complete, consistent set of clients
 func WithKubeClient(kubeclient *kubernetes.Clientset) KubeClusterOption {
 	return func(kc *KubeCluster) {
 		kc.KubeClient = kubeclient
 	}
 }
 
+//WithKubeClients shares an existing set of clients with the cluster instead of building them from the kubeconfig
+func WithKubeClients(clients *KubeClients) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.Clients = clients
+	}
+}
+
+//WithScheme sets the scheme used by the controller-runtime client returned from RuntimeClient
+func WithScheme(scheme *runtime.Scheme) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.Scheme = scheme
+	}
+}
+
 func WithNamespaces(namespaces ...string) KubeClusterOption {
 	return func(kc *KubeCluster) {
 		kc.Namespaces = append(kc.Namespaces, namespaces...)
 	}
 }
 
+//WithNamespaceOptions sets the options used when creating the namespaces from WithNamespaces
+func WithNamespaceOptions(options ...NamespaceOption) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.NamespaceOptions = append(kc.NamespaceOptions, options...)
+	}
+}
+
 func WithHelmCharts(charts ...*HelmChart) KubeClusterOption {
 	return func(kc *KubeCluster) {
 		kc.Charts = append(kc.Charts, charts...)
@@ -134,6 +224,15 @@ func WithImages(images ...string) KubeClusterOption {
 	}
 }
 
+//WithFailureDiagnostics collects diagnostics whenever cluster setup, a job or a chart installation fails. The
+//bundle is kept in LastDiagnostics and also written beneath dir unless dir is empty
+func WithFailureDiagnostics(dir string) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.DiagnoseFailures = true
+		kc.DiagnosticsDir = dir
+	}
+}
+
 func NewKubeCluster(options ...KubeClusterOption) (*KubeCluster, error) {
 	provider := NewProvider()
 	home, err := os.UserHomeDir()
@@ -153,6 +252,7 @@ func NewKubeCluster(options ...KubeClusterOption) (*KubeCluster, error) {
 		ImageRegister:    nil,
 		RegistryPort:     5000,
 		RegistryName:     "kind-registry",
+		Network:          kindNetwork,
 		KubeResourcer:    nil,
 	}
 
@@ -160,10 +260,41 @@ func NewKubeCluster(options ...KubeClusterOption) (*KubeCluster, error) {
 		option(c)
 	}
 
-	kindConfig := NewKindConfig(c.Name, c.ControlCount, c.WorkerCount, c.NodePorts, c.RegistryName, string(c.RegistryPort))
+	c.RegistryOptions = append([]RegistryOption{WithRegistryNetwork(c.Network)}, c.RegistryOptions...)
+	for _, mirror := range c.Mirrors {
+		mirror.Options = append([]RegistryOption{WithRegistryNetwork(c.Network)}, mirror.Options...)
+	}
+
+	if c.AirGapped {
+		err = c.prepareAirGap(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("NewKubeCluster: %w", err)
+		}
+	}
+
+	kindConfig := NewKindConfig(c.Name, c.ControlCount, c.WorkerCount, c.NodePorts, c.RegistryName, strconv.Itoa(c.RegistryPort))
 
 	c.KindConfig = kindConfig
 
+	for _, mirror := range c.Mirrors {
+		c.KindConfig.Mirrors = append(c.KindConfig.Mirrors, mirror.KindMirror())
+	}
+
+	if c.RegistryCertDir != "" {
+		certDir, err := filepath.Abs(c.RegistryCertDir)
+		if err != nil {
+			return nil, fmt.Errorf("NewKubeCluster: %w", err)
+		}
+
+		err = GenerateRegistryCerts(certDir, c.RegistryName, "localhost", "127.0.0.1")
+		if err != nil {
+			return nil, fmt.Errorf("NewKubeCluster: %w", err)
+		}
+
+		c.KindConfig.RegistryCAFile = filepath.Join(certDir, registryCAFile)
+		c.RegistryOptions = append(c.RegistryOptions, WithTLS(certDir))
+	}
+
 	if c.Status == Dead {
 		err = c.Start()
 		if err != nil {
@@ -171,21 +302,87 @@ func NewKubeCluster(options ...KubeClusterOption) (*KubeCluster, error) {
 		}
 	}
 
-	if c.ImageRegister == nil {
-		registry, err := NewRegistry(context.TODO(), c.RegistryName, strconv.Itoa(c.RegistryPort), strconv.Itoa(c.RegistryPort))
+	if c.Clients == nil {
+		clients, err := NewKubeClientsFromPath(c.KubeConfigPath)
 		if err != nil {
 			return nil, fmt.Errorf("NewKubeCluster: %w", err)
 		}
 
+		c.Clients = clients
+	}
+
+	if c.Scheme != nil {
+		c.Clients.Scheme = c.Scheme
+	}
+
+	if c.KubeClient == nil {
+		c.KubeClient = c.Clients.Client
+	}
+
+	if c.ImageRegister == nil && c.LoadImages {
+		loader, err := NewNodeImageLoader(c.Provider, c.Name, c.ClusterImagePrefix())
+		if err != nil {
+			return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
+		}
+
+		c.ImageRegister = loader
+	}
+
+	if c.ImageRegister == nil {
+		registry, err := NewRegistry(context.TODO(), c.RegistryName, strconv.Itoa(c.RegistryPort), strconv.Itoa(c.RegistryPort), c.RegistryOptions...)
+		if err != nil {
+			return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
+		}
+
 		c.ImageRegister = registry
 	}
 
+	for _, mirror := range c.Mirrors {
+		registry, err := NewMirrorRegistry(context.TODO(), mirror.Name, strconv.Itoa(mirror.Port), mirror.Upstream, mirror.Options...)
+		if err != nil {
+			return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
+		}
+
+		err = registry.Acquire(context.TODO(), c.Name)
+		if err != nil {
+			return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
+		}
+
+		c.MirrorRegistries = append(c.MirrorRegistries, registry)
+	}
+
+	acquireRegistry := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		return c.ImageRegister.Acquire(ctx, c.Name)
+	}
+
+	err = acquireRegistry()
+	if err != nil {
+		return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
+	}
+
+	publishHosting := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		return c.PublishRegistryHosting(ctx)
+	}
+
+	if _, ok := c.ImageRegister.(*ClusterRegistry); ok {
+		err = publishHosting()
+		if err != nil {
+			return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
+		}
+	}
+
 	for _, image := range c.Images {
 		pushImage := func() error {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 			defer cancel()
 
-			err = c.ImageRegister.PushImage(ctx, image)
+			_, err = c.ImageRegister.PushImage(ctx, image)
 			if err != nil {
 				return err
 			}
@@ -195,26 +392,12 @@ func NewKubeCluster(options ...KubeClusterOption) (*KubeCluster, error) {
 
 		err = pushImage()
 		if err != nil {
-			return nil, fmt.Errorf("NewKubeCluster: %w", err)
-		}
-	}
-
-	if c.KubeClient == nil {
-		kubeclient, err := createKubeClient(c.KubeConfigPath)
-		if err != nil {
-			return nil, fmt.Errorf("NewKubeCluster: %w", err)
+			return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
 		}
-
-		c.KubeClient = kubeclient
 	}
 
 	if c.KubeResourcer == nil {
-		resourcer, err := NewKubeResourceManager(c.KubeConfigPath)
-		if err != nil {
-			return nil, fmt.Errorf("NewKubeCluster: %w", err)
-		}
-
-		c.KubeResourcer = resourcer
+		c.KubeResourcer = NewKubeResourceManagerForClients(c.Clients)
 	}
 
 	for _, ns := range c.Namespaces {
@@ -222,7 +405,12 @@ func NewKubeCluster(options ...KubeClusterOption) (*KubeCluster, error) {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 			defer cancel()
 
-			err = c.CreateNamespace(ctx, ns)
+			err = c.CreateNamespace(ctx, ns, c.NamespaceOptions...)
+			if err != nil {
+				return err
+			}
+
+			err = c.ConfigurePullSecret(ctx, ns)
 			if err != nil {
 				return err
 			}
@@ -232,13 +420,13 @@ func NewKubeCluster(options ...KubeClusterOption) (*KubeCluster, error) {
 
 		err = createNs()
 		if err != nil {
-			return nil, fmt.Errorf("NewKubeCluster: %w", err)
+			return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
 		}
 	}
 
 	c.HelmResourcer, err = NewHelmChartManager(c.KubeConfigPath)
 	if err != nil {
-		return nil, fmt.Errorf("NewKubeCluster: %w", err)
+		return nil, c.diagnoseFailure(fmt.Errorf("NewKubeCluster: %w", err))
 	}
 
 	for _, chart := range c.Charts {
@@ -274,15 +462,17 @@ func (kc *KubeCluster) Start() error {
 	}
 
 	for attempts := 0; attempts < kc.MaxStartAttempts; attempts++ {
-		err := kc.Provider.Create(
-			kc.Name,
-			cluster.CreateWithNodeImage(""),
-			cluster.CreateWithRetain(false),
-			cluster.CreateWithWaitForReady(time.Duration(0)),
-			cluster.CreateWithKubeconfigPath(kc.KubeConfigPath),
-			cluster.CreateWithDisplayUsage(false),
-			cluster.CreateWithRawConfig([]byte(kc.KindConfig.String())),
-		)
+		err := kc.withKindNetwork(func() error {
+			return kc.Provider.Create(
+				kc.Name,
+				cluster.CreateWithNodeImage(kc.NodeImage),
+				cluster.CreateWithRetain(false),
+				cluster.CreateWithWaitForReady(time.Duration(0)),
+				cluster.CreateWithKubeconfigPath(kc.KubeConfigPath),
+				cluster.CreateWithDisplayUsage(false),
+				cluster.CreateWithRawConfig([]byte(kc.KindConfig.String())),
+			)
+		})
 		if err != nil {
 			if attempts == kc.MaxStartAttempts-1 {
 				return fmt.Errorf("KubeCluster.start: %w", &ExceededMaxAttemptError{
@@ -331,11 +521,31 @@ func (kc *KubeCluster) Delete() error {
 		}
 	}
 
-	err = kc.ImageRegister.Delete(context.TODO())
+	err = kc.ImageRegister.Release(context.TODO(), kc.Name)
 	if err != nil {
 		return fmt.Errorf("KubeCluster.Delete: %s", err)
 	}
 
+	for _, mirror := range kc.MirrorRegistries {
+		err = mirror.Release(context.TODO(), kc.Name)
+		if err != nil {
+			return fmt.Errorf("KubeCluster.Delete: %s", err)
+		}
+	}
+
+	//the network is only removed once nothing, e.g. a registry shared with another cluster, is attached to it
+	if kc.Network != kindNetwork {
+		cli, err := NewContainerClient()
+		if err != nil {
+			return fmt.Errorf("KubeCluster.Delete: %s", err)
+		}
+
+		_, err = RemoveNetwork(context.TODO(), cli, kc.Network)
+		if err != nil {
+			return fmt.Errorf("KubeCluster.Delete: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -392,18 +602,28 @@ func checkForExistingCluster(provider *cluster.Provider, clusterName string) (bo
 	return false, nil
 }
 
-func createKubeClient(path string) (*kubernetes.Clientset, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", path)
-	if err != nil {
-		return nil, fmt.Errorf("createKubeClient: %s", err)
-	}
+//RESTConfig returns the rest.Config shared by all of the cluster's clients
+func (kc *KubeCluster) RESTConfig() *rest.Config {
+	return kc.Clients.RestConfig
+}
+
+func (kc *KubeCluster) DynamicClient() dynamic.Interface {
+	return kc.Clients.DynamicClient
+}
+
+//RESTMapper returns the shared discovery backed RESTMapper. Call Reset after registering new kinds
+func (kc *KubeCluster) RESTMapper() meta.ResettableRESTMapper {
+	return kc.Clients.Mapper
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
+//RuntimeClient returns a controller-runtime client for the cluster, built on first use
+func (kc *KubeCluster) RuntimeClient() (client.Client, error) {
+	c, err := kc.Clients.RuntimeClient()
 	if err != nil {
-		return nil, fmt.Errorf("createKubeClient: %s", err)
+		return nil, fmt.Errorf("KubeCluster.RuntimeClient: %w", err)
 	}
 
-	return clientset, nil
+	return c, nil
 }
 
 func NewProvider() *cluster.Provider {
@@ -1,6 +1,7 @@
 package kubby
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -10,21 +11,48 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 )
 
 type Container struct {
-	Client   *client.Client
-	Id       string
-	Name     string
-	Image    string
-	Tag      string
-	Networks []string
-	Ports    map[string]string
+	Client        *client.Client
+	Id            string
+	Name          string
+	Image         string
+	Tag           string
+	Networks      []string
+	Ports         map[string]string
+	Labels        map[string]string
+	Env           []string
+	Entrypoint    []string
+	Cmd           []string
+	Mounts        []mount.Mount
+	RestartPolicy container.RestartPolicy
+	Memory        int64
+	NanoCPUs      int64
+	User          string
+	WorkingDir    string
+	Privileged    bool
+	Pull          PullPolicy
+	Healthcheck   *container.HealthConfig
+	HealthChecks  []HealthCheck
+	ReadyTimeout  time.Duration
 }
 
+//PullPolicy decides when Start pulls the container's image, with the same meaning as a Kubernetes imagePullPolicy
+type PullPolicy string
+
+const (
+	PullAlways       PullPolicy = "Always"
+	PullIfNotPresent PullPolicy = "IfNotPresent"
+	PullNever        PullPolicy = "Never"
+)
+
 type ContainerOption func(c *Container)
 
 func WithContainerName(name string) ContainerOption {
@@ -57,6 +85,19 @@ func WithPort(imagePort string, hostPort string) ContainerOption {
 	}
 }
 
+//WithPullPolicy sets when the image is pulled. When unset, images tagged latest are always pulled and any other tag
+//only when it isn't present locally
+func WithPullPolicy(policy PullPolicy) ContainerOption {
+	return func(c *Container) {
+		c.Pull = policy
+	}
+}
+
+//WithOffline never pulls the container's image, failing if it isn't present locally
+func WithOffline() ContainerOption {
+	return WithPullPolicy(PullNever)
+}
+
 func WithClient(cli *client.Client) ContainerOption {
 	return func(c *Container) {
 		c.Client = cli
@@ -65,7 +106,11 @@ func WithClient(cli *client.Client) ContainerOption {
 
 func NewContainer(ctx context.Context, options ...ContainerOption) (*Container, error) {
 	c := &Container{
-		Tag: "latest",
+		Tag:   "latest",
+		Ports: map[string]string{},
+		Labels: map[string]string{
+			managedLabel: "true",
+		},
 	}
 
 	for _, option := range options {
@@ -96,6 +141,16 @@ func NewContainer(ctx context.Context, options ...ContainerOption) (*Container,
 		return nil, fmt.Errorf("NewContainer: %w", err)
 	}
 
+	if c.ReadyTimeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, c.ReadyTimeout)
+		defer cancel()
+
+		err = c.WaitReady(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("NewContainer: %w", err)
+		}
+	}
+
 	return c, nil
 }
 
@@ -103,7 +158,12 @@ func (c *Container) Start(ctx context.Context) error {
 	fmt.Printf("starting container %s:%s\n", c.Image, c.Tag)
 
 	fullImage := fmt.Sprintf("%s:%s", c.Image, c.Tag)
-	err := pullImage(ctx, c.Client, fullImage)
+	err := ensureImage(ctx, c.Client, fullImage, c.pullPolicy())
+	if err != nil {
+		return fmt.Errorf("Container.Start: %w", err)
+	}
+
+	err = c.ensureNetworks(ctx)
 	if err != nil {
 		return fmt.Errorf("Container.Start: %w", err)
 	}
@@ -118,10 +178,24 @@ func (c *Container) Start(ctx context.Context) error {
 	cont, err := c.Client.ContainerCreate(
 		ctx,
 		&container.Config{
-			Image: fullImage,
+			Image:       fullImage,
+			Labels:      c.Labels,
+			Env:         c.Env,
+			Entrypoint:  c.Entrypoint,
+			Cmd:         c.Cmd,
+			User:        c.User,
+			WorkingDir:  c.WorkingDir,
+			Healthcheck: c.Healthcheck,
 		},
 		&container.HostConfig{
-			PortBindings: portMap,
+			PortBindings:  portMap,
+			Mounts:        c.Mounts,
+			RestartPolicy: c.RestartPolicy,
+			Privileged:    c.Privileged,
+			Resources: container.Resources{
+				Memory:   c.Memory,
+				NanoCPUs: c.NanoCPUs,
+			},
 		},
 		&network.NetworkingConfig{
 			EndpointsConfig: endpoints,
@@ -167,6 +241,45 @@ func (c *Container) Delete(ctx context.Context) error {
 	return nil
 }
 
+func (c *Container) pullPolicy() PullPolicy {
+	if c.Pull != "" {
+		return c.Pull
+	}
+
+	if c.Tag == "latest" {
+		return PullAlways
+	}
+
+	return PullIfNotPresent
+}
+
+//ensureImage makes image available locally according to policy
+func ensureImage(ctx context.Context, cli *client.Client, image string, policy PullPolicy) error {
+	if policy != PullAlways {
+		missing, err := missingImages(ctx, cli, []string{image})
+		if err != nil {
+			return fmt.Errorf("ensureImage: %w", err)
+		}
+
+		if len(missing) == 0 {
+			return nil
+		}
+
+		if policy == PullNever {
+			return fmt.Errorf("ensureImage: %w", &MissingImageError{
+				images: missing,
+			})
+		}
+	}
+
+	err := pullImage(ctx, cli, image)
+	if err != nil {
+		return fmt.Errorf("ensureImage: %w", err)
+	}
+
+	return nil
+}
+
 func pullImage(ctx context.Context, cli *client.Client, image string) error {
 	out, err := cli.ImagePull(ctx, image, types.ImagePullOptions{})
 	if err != nil {
@@ -217,28 +330,113 @@ func endpointConfig(networks []string, containerName string) map[string]*network
 	return endpoints
 }
 
-func GetContainerId(name string) (string, error) {
-	cli, err := NewContainerClient()
+//execInContainer runs cmd in the container and returns its exit code and combined output
+func execInContainer(ctx context.Context, cli *client.Client, id string, cmd []string) (int, []byte, error) {
+	exec, err := cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
 	if err != nil {
-		return "", fmt.Errorf("GetContainerId: %w", err)
+		return 0, nil, fmt.Errorf("execInContainer: %w", err)
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return 0, nil, fmt.Errorf("execInContainer: %w", err)
+	}
+	defer attach.Close()
+
+	output := &bytes.Buffer{}
+	_, err = stdcopy.StdCopy(output, output, attach.Reader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("execInContainer: %w", err)
+	}
+
+	inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("execInContainer: %w", err)
+	}
+
+	return inspect.ExitCode, output.Bytes(), nil
+}
+
+//ContainerSummary identifies a container found by GetContainer or FindContainers
+type ContainerSummary struct {
+	Id     string
+	Name   string
+	Image  string
+	State  string
+	Labels map[string]string
+}
+
+//GetContainer looks up the container named name, running or stopped
+func GetContainer(ctx context.Context, cli *client.Client, name string) (*ContainerSummary, error) {
+	cont, err := findContainerByName(ctx, cli, name)
+	if err != nil {
+		return nil, fmt.Errorf("GetContainer: %w", err)
 	}
 
-	containers, err := cli.ContainerList(context.TODO(), types.ContainerListOptions{})
+	if cont == nil {
+		return nil, fmt.Errorf("GetContainer: %w", &BadContainerNameError{
+			name: name,
+		})
+	}
+
+	return newContainerSummary(cont), nil
+}
+
+//GetContainerId returns the id of the container named name, running or stopped
+func GetContainerId(ctx context.Context, cli *client.Client, name string) (string, error) {
+	cont, err := GetContainer(ctx, cli, name)
 	if err != nil {
 		return "", fmt.Errorf("GetContainerId: %w", err)
 	}
 
-	for _, container := range containers {
-		for _, n := range container.Names {
-			if name == strings.Trim(n, "/") {
-				return n, nil
-			}
+	return cont.Id, nil
+}
+
+//FindContainers returns every container, running or stopped, carrying all of labels. An empty value matches any
+//value of that label. Containers started by kubby carry the io.platform-edn.kubby.managed label
+func FindContainers(ctx context.Context, cli *client.Client, labels map[string]string) ([]*ContainerSummary, error) {
+	args := filters.NewArgs()
+	for k, v := range labels {
+		if v == "" {
+			args.Add("label", k)
+		} else {
+			args.Add("label", fmt.Sprintf("%s=%s", k, v))
 		}
 	}
 
-	return "", fmt.Errorf("GetContainerId: %w", &BadContainerNameError{
-		name: name,
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: args,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("FindContainers: %w", err)
+	}
+
+	summaries := []*ContainerSummary{}
+	for i := range containers {
+		summaries = append(summaries, newContainerSummary(&containers[i]))
+	}
+
+	return summaries, nil
+}
+
+func newContainerSummary(cont *types.Container) *ContainerSummary {
+	summary := &ContainerSummary{
+		Id:     cont.ID,
+		Image:  cont.Image,
+		State:  cont.State,
+		Labels: cont.Labels,
+	}
+
+	if len(cont.Names) != 0 {
+		summary.Name = strings.TrimPrefix(cont.Names[0], "/")
+	}
+
+	return summary
 }
 
 func NewContainerClient() (*client.Client, error) {
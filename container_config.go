@@ -0,0 +1,103 @@
+package kubby
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+//WithEnv sets an environment variable in the container
+func WithEnv(key string, value string) ContainerOption {
+	return func(c *Container) {
+		c.Env = append(c.Env, fmt.Sprintf("%s=%s", key, value))
+	}
+}
+
+//WithEntrypoint overrides the image's ENTRYPOINT
+func WithEntrypoint(entrypoint ...string) ContainerOption {
+	return func(c *Container) {
+		c.Entrypoint = entrypoint
+	}
+}
+
+//WithCmd overrides the image's CMD, the arguments passed to the entrypoint
+func WithCmd(cmd ...string) ContainerOption {
+	return func(c *Container) {
+		c.Cmd = cmd
+	}
+}
+
+//WithBindMount mounts the host path source at target
+func WithBindMount(source string, target string, readOnly bool) ContainerOption {
+	return func(c *Container) {
+		c.Mounts = append(c.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   source,
+			Target:   target,
+			ReadOnly: readOnly,
+		})
+	}
+}
+
+//WithVolume mounts the named docker volume at target, creating it if it doesn't exist
+func WithVolume(volume string, target string) ContainerOption {
+	return func(c *Container) {
+		c.Mounts = append(c.Mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: volume,
+			Target: target,
+		})
+	}
+}
+
+func WithLabel(key string, value string) ContainerOption {
+	return func(c *Container) {
+		c.Labels[key] = value
+	}
+}
+
+//WithRestartPolicy sets the docker restart policy, one of no, always, unless-stopped or on-failure. maxRetries only
+//applies to on-failure
+func WithRestartPolicy(name string, maxRetries int) ContainerOption {
+	return func(c *Container) {
+		c.RestartPolicy = container.RestartPolicy{
+			Name:              name,
+			MaximumRetryCount: maxRetries,
+		}
+	}
+}
+
+//WithMemoryLimit caps the container's memory in bytes
+func WithMemoryLimit(bytes int64) ContainerOption {
+	return func(c *Container) {
+		c.Memory = bytes
+	}
+}
+
+//WithCPULimit caps the container at cpus cores, fractions such as 0.5 are allowed
+func WithCPULimit(cpus float64) ContainerOption {
+	return func(c *Container) {
+		c.NanoCPUs = int64(cpus * 1e9)
+	}
+}
+
+//WithUser runs the container's process as user, a name or uid with an optional :group
+func WithUser(user string) ContainerOption {
+	return func(c *Container) {
+		c.User = user
+	}
+}
+
+func WithWorkingDir(dir string) ContainerOption {
+	return func(c *Container) {
+		c.WorkingDir = dir
+	}
+}
+
+//WithPrivileged runs the container with every capability and access to the host's devices
+func WithPrivileged() ContainerOption {
+	return func(c *Container) {
+		c.Privileged = true
+	}
+}
@@ -0,0 +1,120 @@
+package kubby
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+//ContainerInfo is the subset of docker inspect output useful from tests
+type ContainerInfo struct {
+	Id          string
+	Status      string
+	Running     bool
+	ExitCode    int
+	Health      string
+	IPAddresses map[string]string
+}
+
+//Logs writes the container's stdout and stderr to w. With follow it keeps streaming until the container stops or
+//ctx is done
+func (c *Container) Logs(ctx context.Context, follow bool, w io.Writer) error {
+	logs, err := c.Client.ContainerLogs(ctx, c.Id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+	})
+	if err != nil {
+		return fmt.Errorf("Container.Logs: %w", err)
+	}
+	defer logs.Close()
+
+	_, err = stdcopy.StdCopy(w, w, logs)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Container.Logs: %w", err)
+	}
+
+	return nil
+}
+
+//Exec runs cmd in the container and returns its exit code and combined output
+func (c *Container) Exec(ctx context.Context, cmd ...string) (int, []byte, error) {
+	code, out, err := execInContainer(ctx, c.Client, c.Id, cmd)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Container.Exec: %w", err)
+	}
+
+	return code, out, nil
+}
+
+//Inspect returns the container's current state and its address on each network it is attached to
+func (c *Container) Inspect(ctx context.Context) (*ContainerInfo, error) {
+	inspect, err := c.Client.ContainerInspect(ctx, c.Id)
+	if err != nil {
+		return nil, fmt.Errorf("Container.Inspect: %w", err)
+	}
+
+	info := &ContainerInfo{
+		Id:          inspect.ID,
+		Status:      inspect.State.Status,
+		Running:     inspect.State.Running,
+		ExitCode:    inspect.State.ExitCode,
+		IPAddresses: map[string]string{},
+	}
+
+	if inspect.State.Health != nil {
+		info.Health = inspect.State.Health.Status
+	}
+
+	for name, settings := range inspect.NetworkSettings.Networks {
+		info.IPAddresses[name] = settings.IPAddress
+	}
+
+	return info, nil
+}
+
+//CopyTo copies the file or directory at src on the host into the directory dst in the container
+func (c *Container) CopyTo(ctx context.Context, src string, dst string) error {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return fmt.Errorf("Container.CopyTo: %w", err)
+	}
+
+	content, err := archive.TarWithOptions(filepath.Dir(src), &archive.TarOptions{
+		IncludeFiles: []string{filepath.Base(src)},
+	})
+	if err != nil {
+		return fmt.Errorf("Container.CopyTo: %w", err)
+	}
+	defer content.Close()
+
+	err = c.Client.CopyToContainer(ctx, c.Id, dst, content, types.CopyToContainerOptions{})
+	if err != nil {
+		return fmt.Errorf("Container.CopyTo: %w", err)
+	}
+
+	return nil
+}
+
+//CopyFrom copies the file or directory at src in the container into the directory dst on the host
+func (c *Container) CopyFrom(ctx context.Context, src string, dst string) error {
+	content, _, err := c.Client.CopyFromContainer(ctx, c.Id, src)
+	if err != nil {
+		return fmt.Errorf("Container.CopyFrom: %w", err)
+	}
+	defer content.Close()
+
+	err = archive.Untar(content, dst, &archive.TarOptions{
+		NoLchown: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Container.CopyFrom: %w", err)
+	}
+
+	return nil
+}
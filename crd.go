@@ -0,0 +1,245 @@
+package kubby
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+//CustomResourceCondition reports whether a custom resource has reached the desired state
+type CustomResourceCondition func(*unstructured.Unstructured) (bool, error)
+
+//InstallCRDs applies every CustomResourceDefinition found in paths, waits for each to be Established and
+//refreshes the RESTMapper so custom resources can be created immediately afterwards. Directories are
+//searched for .yaml, .yml and .json files
+func (manager *KubeResourceManager) InstallCRDs(ctx context.Context, paths ...string) error {
+	crds := []*apiextensionsv1.CustomResourceDefinition{}
+
+	for _, path := range paths {
+		found, err := readCRDs(path)
+		if err != nil {
+			return fmt.Errorf("InstallCRDs: %w", err)
+		}
+
+		crds = append(crds, found...)
+	}
+
+	client := manager.ExtensionsClient.ApiextensionsV1().CustomResourceDefinitions()
+
+	for _, crd := range crds {
+		fmt.Printf("installing crd %s ...\n", crd.Name)
+
+		_, err := client.Create(ctx, crd, metav1.CreateOptions{})
+		if err != nil {
+			if !k8serrors.IsAlreadyExists(err) {
+				return fmt.Errorf("InstallCRDs: %w", err)
+			}
+
+			existing, err := client.Get(ctx, crd.Name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("InstallCRDs: %w", err)
+			}
+
+			crd.ResourceVersion = existing.ResourceVersion
+			_, err = client.Update(ctx, crd, metav1.UpdateOptions{})
+			if err != nil {
+				return fmt.Errorf("InstallCRDs: %w", err)
+			}
+		}
+	}
+
+	for _, crd := range crds {
+		err := waitForCRD(ctx, manager, crd.Name, time.Second/2)
+		if err != nil {
+			return fmt.Errorf("InstallCRDs: %w", err)
+		}
+	}
+
+	manager.Mapper.Reset()
+
+	return nil
+}
+
+//CreateCustomResource creates obj using the resource its GroupVersionKind maps to
+func (manager *KubeResourceManager) CreateCustomResource(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	client, err := manager.resourceClient(obj.GroupVersionKind(), obj.GetNamespace())
+	if err != nil {
+		return nil, fmt.Errorf("CreateCustomResource: %w", err)
+	}
+
+	created, err := client.Create(ctx, obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("CreateCustomResource: %w", err)
+	}
+
+	return created, nil
+}
+
+//GetCustomResource fetches the custom resource of kind gvk. namespace is ignored for cluster scoped kinds
+func (manager *KubeResourceManager) GetCustomResource(ctx context.Context, gvk schema.GroupVersionKind, namespace string, name string) (*unstructured.Unstructured, error) {
+	client, err := manager.resourceClient(gvk, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("GetCustomResource: %w", err)
+	}
+
+	obj, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("GetCustomResource: %w", err)
+	}
+
+	return obj, nil
+}
+
+//WaitForCustomResource polls the custom resource every interval until condition is met or ctx is done
+func (manager *KubeResourceManager) WaitForCustomResource(ctx context.Context, gvk schema.GroupVersionKind, namespace string, name string, interval time.Duration, condition CustomResourceCondition) error {
+	client, err := manager.resourceClient(gvk, namespace)
+	if err != nil {
+		return fmt.Errorf("WaitForCustomResource: %w", err)
+	}
+
+	err = wait.PollImmediateUntil(interval, func() (bool, error) {
+		obj, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		return condition(obj)
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("WaitForCustomResource: %w", err)
+	}
+
+	return nil
+}
+
+func (manager *KubeResourceManager) resourceClient(gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, error) {
+	mapping, err := manager.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		if !meta.IsNoMatchError(err) {
+			return nil, fmt.Errorf("resourceClient: %w", err)
+		}
+
+		//the kind may have been registered after the mapper last looked at discovery
+		manager.Mapper.Reset()
+		mapping, err = manager.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, fmt.Errorf("resourceClient: %w", err)
+		}
+	}
+
+	resource := manager.DynamicClient.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return resource.Namespace(namespace), nil
+	}
+
+	return resource, nil
+}
+
+func waitForCRD(ctx context.Context, manager *KubeResourceManager, name string, interval time.Duration) error {
+	client := manager.ExtensionsClient.ApiextensionsV1().CustomResourceDefinitions()
+
+	err := wait.PollImmediateUntil(interval, func() (bool, error) {
+		crd, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		for _, cond := range crd.Status.Conditions {
+			if cond.Type == apiextensionsv1.NamesAccepted && cond.Status == apiextensionsv1.ConditionFalse {
+				return false, &CRDNotEstablishedError{
+					name:   name,
+					reason: cond.Message,
+				}
+			}
+
+			if cond.Type == apiextensionsv1.Established && cond.Status == apiextensionsv1.ConditionTrue {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("waitForCRD: %w", err)
+	}
+
+	return nil
+}
+
+func readCRDs(path string) ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("readCRDs: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = []string{}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("readCRDs: %w", err)
+		}
+
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+	}
+
+	crds := []*apiextensionsv1.CustomResourceDefinition{}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("readCRDs: %w", err)
+		}
+
+		decoder := yaml.NewYAMLOrJSONDecoder(f, 4096)
+		for {
+			crd := &apiextensionsv1.CustomResourceDefinition{}
+			err = decoder.Decode(crd)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("readCRDs: %s: %w", file, err)
+			}
+
+			//skip empty documents and anything that isn't a crd
+			if crd.Kind != "CustomResourceDefinition" || crd.Name == "" {
+				continue
+			}
+
+			crds = append(crds, crd)
+		}
+
+		f.Close()
+	}
+
+	return crds, nil
+}
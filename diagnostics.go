@@ -0,0 +1,304 @@
+package kubby
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubectl/pkg/describe"
+	"sigs.k8s.io/yaml"
+)
+
+//DiagnosticBundle holds collected diagnostics in memory, keyed by relative file path
+type DiagnosticBundle struct {
+	Files map[string][]byte
+}
+
+func NewDiagnosticBundle() *DiagnosticBundle {
+	return &DiagnosticBundle{
+		Files: map[string][]byte{},
+	}
+}
+
+func (bundle *DiagnosticBundle) Add(path string, content []byte) {
+	bundle.Files[path] = content
+}
+
+//Paths returns the file paths in the bundle in sorted order
+func (bundle *DiagnosticBundle) Paths() []string {
+	paths := []string{}
+	for path := range bundle.Files {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+//Write writes every file in the bundle under dir, creating it if needed
+func (bundle *DiagnosticBundle) Write(dir string) error {
+	for path, content := range bundle.Files {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+
+		err := os.MkdirAll(filepath.Dir(full), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("DiagnosticBundle.Write: %w", err)
+		}
+
+		err = ioutil.WriteFile(full, content, 0644)
+		if err != nil {
+			return fmt.Errorf("DiagnosticBundle.Write: %w", err)
+		}
+	}
+
+	return nil
+}
+
+//CollectDiagnostics gathers events, pod descriptions, container statuses, container logs (including the
+//previous instance of restarted containers) and helm release status for the given namespaces. All namespaces
+//are collected if none are given. Collection is best effort, anything that can't be gathered is recorded in the
+//bundle in place of its content
+func (kc *KubeCluster) CollectDiagnostics(ctx context.Context, namespaces ...string) (*DiagnosticBundle, error) {
+	if kc.KubeClient == nil {
+		return nil, fmt.Errorf("KubeCluster.CollectDiagnostics: %w", &MissingFieldError{
+			field: "KubeClient",
+		})
+	}
+
+	if len(namespaces) == 0 {
+		list, err := kc.KubeClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("KubeCluster.CollectDiagnostics: %w", err)
+		}
+
+		for _, ns := range list.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+
+	bundle := NewDiagnosticBundle()
+
+	for _, ns := range namespaces {
+		collectEvents(ctx, kc, ns, bundle)
+		collectPods(ctx, kc, ns, bundle)
+		collectReleases(kc, ns, bundle)
+	}
+
+	return bundle, nil
+}
+
+//RunJob runs the job through the cluster's KubeResourcer, collecting diagnostics if it fails
+func (kc *KubeCluster) RunJob(ctx context.Context, namespace string, job *batchv1.Job, checkInterval time.Duration) error {
+	err := kc.KubeResourcer.RunJob(ctx, namespace, job, checkInterval)
+	if err != nil {
+		return kc.diagnoseFailure(fmt.Errorf("KubeCluster.RunJob: %w", err), namespace)
+	}
+
+	return nil
+}
+
+//InstallChart installs the chart through the cluster's HelmResourcer, collecting diagnostics if it fails
+func (kc *KubeCluster) InstallChart(name string, namespace string, path string) error {
+	err := kc.HelmResourcer.InstallChart(name, namespace, path)
+	if err != nil {
+		return kc.diagnoseFailure(fmt.Errorf("KubeCluster.InstallChart: %w", err), namespace)
+	}
+
+	return nil
+}
+
+//diagnoseFailure collects diagnostics into kc.LastDiagnostics and, when a diagnostics directory is configured,
+//writes them to a timestamped directory beneath it. The original error is always returned
+func (kc *KubeCluster) diagnoseFailure(failure error, namespaces ...string) error {
+	if !kc.DiagnoseFailures || kc.KubeClient == nil {
+		return failure
+	}
+
+	fmt.Println("collecting diagnostics ...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	bundle, err := kc.CollectDiagnostics(ctx, namespaces...)
+	if err != nil {
+		fmt.Printf("failed collecting diagnostics: %v\n", err)
+		return failure
+	}
+
+	kc.LastDiagnostics = bundle
+
+	if kc.DiagnosticsDir != "" {
+		dir := filepath.Join(kc.DiagnosticsDir, time.Now().Format("20060102-150405"))
+
+		err = bundle.Write(dir)
+		if err != nil {
+			fmt.Printf("failed writing diagnostics: %v\n", err)
+			return failure
+		}
+
+		fmt.Printf("diagnostics written to %s\n", dir)
+	}
+
+	return failure
+}
+
+func collectEvents(ctx context.Context, kc *KubeCluster, namespace string, bundle *DiagnosticBundle) {
+	path := fmt.Sprintf("%s/events.txt", namespace)
+
+	events, err := kc.KubeClient.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		bundle.Add(path, []byte(fmt.Sprintf("failed listing events: %v\n", err)))
+		return
+	}
+
+	sort.Slice(events.Items, func(i, j int) bool {
+		return eventTime(events.Items[i]).Before(eventTime(events.Items[j]))
+	})
+
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTYPE\tREASON\tOBJECT\tCOUNT\tMESSAGE")
+	for _, e := range events.Items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s/%s\t%d\t%s\n",
+			eventTime(e).Format(time.RFC3339),
+			e.Type,
+			e.Reason,
+			strings.ToLower(e.InvolvedObject.Kind),
+			e.InvolvedObject.Name,
+			e.Count,
+			strings.TrimSpace(e.Message),
+		)
+	}
+	w.Flush()
+
+	bundle.Add(path, buf.Bytes())
+}
+
+func eventTime(e apiv1.Event) time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		return e.EventTime.Time
+	}
+
+	return e.CreationTimestamp.Time
+}
+
+func collectPods(ctx context.Context, kc *KubeCluster, namespace string, bundle *DiagnosticBundle) {
+	client := kc.KubeClient.CoreV1().Pods(namespace)
+
+	pods, err := client.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		bundle.Add(fmt.Sprintf("%s/pods.txt", namespace), []byte(fmt.Sprintf("failed listing pods: %v\n", err)))
+		return
+	}
+
+	describer := &describe.PodDescriber{Interface: kc.KubeClient}
+
+	for _, pod := range pods.Items {
+		prefix := fmt.Sprintf("%s/pods/%s", namespace, pod.Name)
+
+		description, err := describer.Describe(namespace, pod.Name, describe.DescriberSettings{
+			ShowEvents: true,
+			ChunkSize:  500,
+		})
+		if err != nil {
+			description = fmt.Sprintf("failed describing pod: %v", err)
+		}
+		bundle.Add(fmt.Sprintf("%s/describe.txt", prefix), []byte(description))
+
+		statuses, err := yaml.Marshal(map[string]interface{}{
+			"phase":                 pod.Status.Phase,
+			"conditions":            pod.Status.Conditions,
+			"initContainerStatuses": pod.Status.InitContainerStatuses,
+			"containerStatuses":     pod.Status.ContainerStatuses,
+		})
+		if err != nil {
+			statuses = []byte(fmt.Sprintf("failed marshalling statuses: %v\n", err))
+		}
+		bundle.Add(fmt.Sprintf("%s/statuses.yaml", prefix), statuses)
+
+		allStatuses := append([]apiv1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+		allStatuses = append(allStatuses, pod.Status.ContainerStatuses...)
+
+		for _, status := range allStatuses {
+			logs := containerLogs(ctx, kc, namespace, pod.Name, status.Name, false)
+			bundle.Add(fmt.Sprintf("%s/%s.log", prefix, status.Name), logs)
+
+			if status.RestartCount > 0 {
+				logs = containerLogs(ctx, kc, namespace, pod.Name, status.Name, true)
+				bundle.Add(fmt.Sprintf("%s/%s.previous.log", prefix, status.Name), logs)
+			}
+		}
+	}
+}
+
+//containerLogs is best effort, a container that never started has no logs so the error is recorded instead
+func containerLogs(ctx context.Context, kc *KubeCluster, namespace string, pod string, container string, previous bool) []byte {
+	tail := int64(1000)
+
+	logs, err := kc.KubeClient.CoreV1().Pods(namespace).GetLogs(pod, &apiv1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
+		TailLines: &tail,
+	}).DoRaw(ctx)
+	if err != nil {
+		return []byte(fmt.Sprintf("failed getting logs: %v\n", err))
+	}
+
+	return logs
+}
+
+func collectReleases(kc *KubeCluster, namespace string, bundle *DiagnosticBundle) {
+	path := fmt.Sprintf("%s/helm-releases.txt", namespace)
+
+	actionConfig, err := newActionConfig(kc.KubeConfigPath, namespace)
+	if err != nil {
+		bundle.Add(path, []byte(fmt.Sprintf("failed configuring helm: %v\n", err)))
+		return
+	}
+
+	list := action.NewList(actionConfig)
+	list.StateMask = action.ListAll
+
+	releases, err := list.Run()
+	if err != nil {
+		bundle.Add(path, []byte(fmt.Sprintf("failed listing releases: %v\n", err)))
+		return
+	}
+
+	if len(releases) == 0 {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tREVISION\tUPDATED\tSTATUS\tCHART\tDESCRIPTION")
+	for _, rel := range releases {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s-%s\t%s\n",
+			rel.Name,
+			rel.Version,
+			rel.Info.LastDeployed.Format(time.RFC3339),
+			rel.Info.Status,
+			rel.Chart.Metadata.Name,
+			rel.Chart.Metadata.Version,
+			rel.Info.Description,
+		)
+	}
+	w.Flush()
+
+	bundle.Add(path, buf.Bytes())
+}
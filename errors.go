@@ -1,6 +1,9 @@
 package kubby
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type ExistingKubeClusterError struct {
 	name string
@@ -65,3 +68,85 @@ type BadPodNameError struct {
 func (err *BadPodNameError) Error() string {
 	return fmt.Sprintf("no pod named %s exists", err.name)
 }
+
+type CRDNotEstablishedError struct {
+	name   string
+	reason string
+}
+
+func (err *CRDNotEstablishedError) Error() string {
+	return fmt.Sprintf("crd %s was not established: %s", err.name, err.reason)
+}
+
+type StuckNamespaceError struct {
+	name       string
+	finalizers []string
+	details    string
+}
+
+func (err *StuckNamespaceError) Error() string {
+	return fmt.Sprintf("namespace %s was not deleted, remaining finalizers %v: %s", err.name, err.finalizers, err.details)
+}
+
+type RegistryNotReadyError struct {
+	url string
+}
+
+func (err *RegistryNotReadyError) Error() string {
+	return fmt.Sprintf("registry at %s did not become ready", err.url)
+}
+
+type ForeignContainerError struct {
+	name string
+}
+
+func (err *ForeignContainerError) Error() string {
+	return fmt.Sprintf("container %s exists but was not created by kubby", err.name)
+}
+
+type RegistryConfigMismatchError struct {
+	name      string
+	label     string
+	existing  string
+	requested string
+}
+
+func (err *RegistryConfigMismatchError) Error() string {
+	return fmt.Sprintf("registry container %s was created with %s=%q but %q was requested, remove it to change the setting", err.name, err.label, err.existing, err.requested)
+}
+
+type MissingImageError struct {
+	images []string
+}
+
+func (err *MissingImageError) Error() string {
+	return fmt.Sprintf("images not present locally: %s", strings.Join(err.images, ", "))
+}
+
+type ContainerCommandError struct {
+	command  string
+	exitCode int
+	output   string
+}
+
+func (err *ContainerCommandError) Error() string {
+	return fmt.Sprintf("%s exited with code %d: %s", err.command, err.exitCode, err.output)
+}
+
+type RegistryRequestError struct {
+	status  int
+	message string
+}
+
+func (err *RegistryRequestError) Error() string {
+	return fmt.Sprintf("registry request failed with status %d: %s", err.status, err.message)
+}
+
+type ContainerNotReadyError struct {
+	name   string
+	reason string
+}
+
+func (err *ContainerNotReadyError) Error() string {
+	return fmt.Sprintf("container %s did not become ready: %s", err.name, err.reason)
+}
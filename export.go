@@ -0,0 +1,297 @@
+package kubby
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+//exportErrorsFile records the parts of an export that failed, the rest of the archive is still written
+const exportErrorsFile = "errors.txt"
+
+//clusterStateList lists one kind of resource across all namespaces for the cluster state dump
+type clusterStateList struct {
+	kind       string
+	apiVersion string
+	list       func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error)
+}
+
+//ExportLogs collects kubelet, containerd and control plane logs from every node, the registry container's logs,
+//the state of all standard workload resources and every helm release manifest, then archives them into a single
+//tar.gz inside dir. Collection is best effort: failures are written to errors.txt in the archive and whatever was
+//gathered is still archived. The path of the archive is returned
+func (kc *KubeCluster) ExportLogs(ctx context.Context, dir string) (string, error) {
+	staging, err := ioutil.TempDir("", fmt.Sprintf("%s-logs-", kc.Name))
+	if err != nil {
+		return "", fmt.Errorf("KubeCluster.ExportLogs: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	fmt.Printf("exporting logs for cluster %s ...\n", kc.Name)
+
+	failures := []error{}
+
+	err = kc.Provider.CollectLogs(kc.Name, filepath.Join(staging, "nodes"))
+	if err != nil {
+		failures = append(failures, fmt.Errorf("node logs: %w", err))
+	}
+
+	if registry, ok := kc.ImageRegister.(*ClusterRegistry); ok {
+		err = writeRegistryLogs(ctx, registry, filepath.Join(staging, "registry.log"))
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if kc.KubeClient != nil {
+		failures = append(failures, writeClusterState(ctx, kc, filepath.Join(staging, "cluster-state.yaml"))...)
+
+		err = writeReleaseManifests(kc, filepath.Join(staging, "helm"))
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+
+	if len(failures) != 0 {
+		err = writeExportErrors(filepath.Join(staging, exportErrorsFile), failures)
+		if err != nil {
+			return "", fmt.Errorf("KubeCluster.ExportLogs: %w", err)
+		}
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("KubeCluster.ExportLogs: %w", err)
+	}
+
+	archivePath := filepath.Join(dir, fmt.Sprintf("%s-logs-%s.tar.gz", kc.Name, time.Now().Format("20060102-150405")))
+
+	err = archiveDir(staging, archivePath)
+	if err != nil {
+		return "", fmt.Errorf("KubeCluster.ExportLogs: %w", err)
+	}
+
+	fmt.Printf("logs written to %s\n", archivePath)
+	if len(failures) != 0 {
+		fmt.Printf("%d parts of the export failed, see %s in the archive\n", len(failures), exportErrorsFile)
+	}
+
+	return archivePath, nil
+}
+
+func writeRegistryLogs(ctx context.Context, registry *ClusterRegistry, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("writeRegistryLogs: %w", err)
+	}
+	defer f.Close()
+
+	err = registry.Logs(ctx, false, f)
+	if err != nil {
+		return fmt.Errorf("writeRegistryLogs: %w", err)
+	}
+
+	return nil
+}
+
+func writeExportErrors(path string, failures []error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("writeExportErrors: %w", err)
+	}
+	defer f.Close()
+
+	for _, failure := range failures {
+		_, err = fmt.Fprintln(f, failure)
+		if err != nil {
+			return fmt.Errorf("writeExportErrors: %w", err)
+		}
+	}
+
+	return nil
+}
+
+//clusterStateLists are the resources kubectl get all -A shows
+func clusterStateLists(kc *KubeCluster) []clusterStateList {
+	client := kc.KubeClient
+
+	return []clusterStateList{
+		{"PodList", "v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Pods("").List(ctx, opts)
+		}},
+		{"ServiceList", "v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Services("").List(ctx, opts)
+		}},
+		{"ReplicationControllerList", "v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().ReplicationControllers("").List(ctx, opts)
+		}},
+		{"DaemonSetList", "apps/v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().DaemonSets("").List(ctx, opts)
+		}},
+		{"DeploymentList", "apps/v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().Deployments("").List(ctx, opts)
+		}},
+		{"ReplicaSetList", "apps/v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().ReplicaSets("").List(ctx, opts)
+		}},
+		{"StatefulSetList", "apps/v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().StatefulSets("").List(ctx, opts)
+		}},
+		{"JobList", "batch/v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.BatchV1().Jobs("").List(ctx, opts)
+		}},
+		{"CronJobList", "batch/v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.BatchV1().CronJobs("").List(ctx, opts)
+		}},
+		{"HorizontalPodAutoscalerList", "autoscaling/v1", func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return client.AutoscalingV1().HorizontalPodAutoscalers("").List(ctx, opts)
+		}},
+	}
+}
+
+//writeClusterState mirrors kubectl get all -A -o yaml. Lists that fail are recorded in the file and returned.
+//batch/v1 CronJobs are skipped on servers older than Kubernetes 1.21, which don't serve them
+func writeClusterState(ctx context.Context, kc *KubeCluster, path string) []error {
+	failures := []error{}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return append(failures, fmt.Errorf("writeClusterState: %w", err))
+	}
+	defer f.Close()
+
+	for _, resource := range clusterStateLists(kc) {
+		list, err := resource.list(ctx, metav1.ListOptions{})
+		if resource.kind == "CronJobList" && (k8serrors.IsNotFound(err) || meta.IsNoMatchError(err)) {
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("writeClusterState: %s: %w", resource.kind, err)
+			failures = append(failures, err)
+			fmt.Fprintf(f, "---\n# %v\n", err)
+			continue
+		}
+
+		list.GetObjectKind().SetGroupVersionKind(schema.FromAPIVersionAndKind(resource.apiVersion, resource.kind))
+
+		out, err := yaml.Marshal(list)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("writeClusterState: %s: %w", resource.kind, err))
+			continue
+		}
+
+		_, err = fmt.Fprintf(f, "---\n%s", out)
+		if err != nil {
+			return append(failures, fmt.Errorf("writeClusterState: %w", err))
+		}
+	}
+
+	return failures
+}
+
+func writeReleaseManifests(kc *KubeCluster, dir string) error {
+	actionConfig, err := newActionConfig(kc.KubeConfigPath, "")
+	if err != nil {
+		return fmt.Errorf("writeReleaseManifests: %w", err)
+	}
+
+	list := action.NewList(actionConfig)
+	list.AllNamespaces = true
+	list.StateMask = action.ListAll
+
+	releases, err := list.Run()
+	if err != nil {
+		return fmt.Errorf("writeReleaseManifests: %w", err)
+	}
+
+	for _, rel := range releases {
+		path := filepath.Join(dir, rel.Namespace, fmt.Sprintf("%s.yaml", rel.Name))
+
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("writeReleaseManifests: %w", err)
+		}
+
+		err = ioutil.WriteFile(path, []byte(rel.Manifest), 0644)
+		if err != nil {
+			return fmt.Errorf("writeReleaseManifests: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func archiveDir(src string, dest string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("archiveDir: %w", err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("archiveDir: %w", err)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("archiveDir: %w", err)
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return fmt.Errorf("archiveDir: %w", err)
+	}
+
+	return nil
+}
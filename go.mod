@@ -5,11 +5,17 @@ go 1.13
 require (
 	github.com/docker/docker v20.10.12+incompatible
 	github.com/docker/go-connections v0.4.0
+	github.com/moby/buildkit v0.8.3
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 	helm.sh/helm/v3 v3.8.0
 	k8s.io/api v0.23.3
+	k8s.io/apiextensions-apiserver v0.23.1
 	k8s.io/apimachinery v0.23.3
 	k8s.io/client-go v0.23.3
+	k8s.io/kubectl v0.23.1
+	sigs.k8s.io/controller-runtime v0.11.0
 	sigs.k8s.io/kind v0.11.1
+	sigs.k8s.io/yaml v1.3.0
 )
 
 require github.com/moby/sys/mount v0.3.0 // indirect
@@ -0,0 +1,218 @@
+package kubby
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+type GoBuildConfig struct {
+	BaseImage string
+	Dir       string
+	GoArch    string
+	Flags     []string
+	Env       []string
+}
+
+type GoBuildOption func(gc *GoBuildConfig)
+
+//WithBaseImage sets the image the binary is layered onto. It must already be present locally
+func WithBaseImage(image string) GoBuildOption {
+	return func(gc *GoBuildConfig) {
+		gc.BaseImage = image
+	}
+}
+
+//WithGoDir sets the directory go build runs in, normally the root of the module containing the import path
+func WithGoDir(dir string) GoBuildOption {
+	return func(gc *GoBuildConfig) {
+		gc.Dir = dir
+	}
+}
+
+func WithGoArch(arch string) GoBuildOption {
+	return func(gc *GoBuildConfig) {
+		gc.GoArch = arch
+	}
+}
+
+//WithGoFlags passes extra flags such as -ldflags or -tags to go build
+func WithGoFlags(flags ...string) GoBuildOption {
+	return func(gc *GoBuildConfig) {
+		gc.Flags = append(gc.Flags, flags...)
+	}
+}
+
+func WithGoEnv(env ...string) GoBuildOption {
+	return func(gc *GoBuildConfig) {
+		gc.Env = append(gc.Env, env...)
+	}
+}
+
+//BuildGoImage compiles importPath with the local go toolchain, layers the binary onto the base image and pushes
+//the result to the registry as name. The image's entrypoint is the binary
+func (r *ClusterRegistry) BuildGoImage(ctx context.Context, importPath string, name string, options ...GoBuildOption) (*PushedImage, error) {
+	image := fmt.Sprintf("%s/%s", r.Url, name)
+
+	fmt.Printf("building %s from %s...\n", image, importPath)
+	err := buildGoImage(ctx, r.Client, importPath, image, options...)
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.BuildGoImage: %w", err)
+	}
+
+	fmt.Printf("pushing %s...\n", image)
+	pushed, err := r.pushWithRetry(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.BuildGoImage: %w", err)
+	}
+
+	return pushed, nil
+}
+
+func buildGoImage(ctx context.Context, cli *client.Client, importPath string, image string, options ...GoBuildOption) error {
+	goConfig := &GoBuildConfig{
+		BaseImage: "gcr.io/distroless/static:nonroot",
+		GoArch:    runtime.GOARCH,
+	}
+
+	for _, option := range options {
+		option(goConfig)
+	}
+
+	_, _, err := cli.ImageInspectWithRaw(ctx, goConfig.BaseImage)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return fmt.Errorf("buildGoImage: %w", &MissingImageError{
+				images: []string{goConfig.BaseImage},
+			})
+		}
+
+		return fmt.Errorf("buildGoImage: %w", err)
+	}
+
+	binary, err := compileGoBinary(ctx, importPath, goConfig)
+	if err != nil {
+		return fmt.Errorf("buildGoImage: %w", err)
+	}
+	defer os.RemoveAll(filepath.Dir(binary))
+
+	layer, err := binaryLayer(binary)
+	if err != nil {
+		return fmt.Errorf("buildGoImage: %w", err)
+	}
+
+	//distroless bases have no ENTRYPOINT or CMD and docker refuses to create a container without a command, so the
+	//binary is set as the entrypoint up front and the same config is committed
+	config := &container.Config{
+		Image:      goConfig.BaseImage,
+		Entrypoint: []string{path.Join("/ko-app", filepath.Base(binary))},
+	}
+
+	cont, err := cli.ContainerCreate(ctx, config, nil, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("buildGoImage: %w", err)
+	}
+	defer cli.ContainerRemove(context.Background(), cont.ID, types.ContainerRemoveOptions{Force: true})
+
+	err = cli.CopyToContainer(ctx, cont.ID, "/", layer, types.CopyToContainerOptions{})
+	if err != nil {
+		return fmt.Errorf("buildGoImage: %w", err)
+	}
+
+	_, err = cli.ContainerCommit(ctx, cont.ID, types.ContainerCommitOptions{
+		Reference: image,
+		Config:    config,
+	})
+	if err != nil {
+		return fmt.Errorf("buildGoImage: %w", err)
+	}
+
+	return nil
+}
+
+//compileGoBinary builds a static linux binary for importPath into a temporary directory and returns its path
+func compileGoBinary(ctx context.Context, importPath string, config *GoBuildConfig) (string, error) {
+	dir, err := ioutil.TempDir("", "kubby-go-")
+	if err != nil {
+		return "", fmt.Errorf("compileGoBinary: %w", err)
+	}
+
+	name := path.Base(strings.TrimSuffix(importPath, "/"))
+	if name == "." || name == "/" || name == "" {
+		name = "app"
+	}
+
+	binary := filepath.Join(dir, name)
+
+	args := append([]string{"build", "-o", binary, "-trimpath"}, config.Flags...)
+	args = append(args, importPath)
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = config.Dir
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", fmt.Sprintf("GOARCH=%s", config.GoArch))
+	cmd.Env = append(cmd.Env, config.Env...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("compileGoBinary: %w", &BadImageBuildError{
+			output: fmt.Sprintf("%v: %s", err, out),
+		})
+	}
+
+	return binary, nil
+}
+
+//binaryLayer tars the binary under /ko-app so it can be copied into the base image's filesystem
+func binaryLayer(binary string) (*bytes.Buffer, error) {
+	content, err := ioutil.ReadFile(binary)
+	if err != nil {
+		return nil, fmt.Errorf("binaryLayer: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:     "ko-app/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("binaryLayer: %w", err)
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:     path.Join("ko-app", filepath.Base(binary)),
+		Typeflag: tar.TypeReg,
+		Mode:     0755,
+		Size:     int64(len(content)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("binaryLayer: %w", err)
+	}
+
+	_, err = tw.Write(content)
+	if err != nil {
+		return nil, fmt.Errorf("binaryLayer: %w", err)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("binaryLayer: %w", err)
+	}
+
+	return buf, nil
+}
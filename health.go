@@ -0,0 +1,253 @@
+package kubby
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+//HealthCheck reports whether a running container is ready for use. String describes the check in errors
+type HealthCheck interface {
+	Healthy(ctx context.Context, c *Container) (bool, error)
+	String() string
+}
+
+//DockerHealthCheck waits for the docker HEALTHCHECK configured on the container, or baked into its image, to pass
+type DockerHealthCheck struct{}
+
+//TCPHealthCheck waits until the container accepts connections on Port
+type TCPHealthCheck struct {
+	Port string
+}
+
+//HTTPHealthCheck waits until a GET of Path on Port answers with a status below 400
+type HTTPHealthCheck struct {
+	Port string
+	Path string
+}
+
+//LogHealthCheck waits until a line of the container's output matches Pattern
+type LogHealthCheck struct {
+	Pattern *regexp.Regexp
+}
+
+//WithDockerHealthCheck sets a docker HEALTHCHECK running cmd inside the container and waits for it in WaitReady
+func WithDockerHealthCheck(cmd []string, interval time.Duration, timeout time.Duration, retries int) ContainerOption {
+	return func(c *Container) {
+		c.Healthcheck = &container.HealthConfig{
+			Test:     append([]string{"CMD"}, cmd...),
+			Interval: interval,
+			Timeout:  timeout,
+			Retries:  retries,
+		}
+		c.HealthChecks = append(c.HealthChecks, &DockerHealthCheck{})
+	}
+}
+
+func WithTCPHealthCheck(port string) ContainerOption {
+	return func(c *Container) {
+		c.HealthChecks = append(c.HealthChecks, &TCPHealthCheck{
+			Port: port,
+		})
+	}
+}
+
+func WithHTTPHealthCheck(port string, path string) ContainerOption {
+	return func(c *Container) {
+		c.HealthChecks = append(c.HealthChecks, &HTTPHealthCheck{
+			Port: port,
+			Path: path,
+		})
+	}
+}
+
+//WithLogHealthCheck waits for a log line matching pattern. Each line is matched on its own so ^ and $ anchor to
+//the line
+func WithLogHealthCheck(pattern *regexp.Regexp) ContainerOption {
+	return func(c *Container) {
+		c.HealthChecks = append(c.HealthChecks, &LogHealthCheck{
+			Pattern: pattern,
+		})
+	}
+}
+
+//WithReadyTimeout makes NewContainer wait up to timeout for the container's health checks to pass
+func WithReadyTimeout(timeout time.Duration) ContainerOption {
+	return func(c *Container) {
+		c.ReadyTimeout = timeout
+	}
+}
+
+//WaitReady blocks until every health check passes, the context is done or the container stops running
+func (c *Container) WaitReady(ctx context.Context) error {
+	reason := "timed out"
+
+	err := wait.PollImmediateUntil(time.Second/4, func() (bool, error) {
+		inspect, err := c.Client.ContainerInspect(ctx, c.Id)
+		if err != nil {
+			return false, err
+		}
+
+		if !inspect.State.Running {
+			reason = fmt.Sprintf("container is %s with exit code %d", inspect.State.Status, inspect.State.ExitCode)
+			return false, &ContainerNotReadyError{
+				name:   c.Name,
+				reason: reason,
+			}
+		}
+
+		for _, check := range c.HealthChecks {
+			healthy, err := check.Healthy(ctx, c)
+			if err != nil {
+				return false, err
+			}
+
+			if !healthy {
+				reason = fmt.Sprintf("%s has not passed", check)
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout {
+		err = &ContainerNotReadyError{
+			name:   c.Name,
+			reason: reason,
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Container.WaitReady: %w", err)
+	}
+
+	return nil
+}
+
+func (hc *DockerHealthCheck) String() string {
+	return "docker HEALTHCHECK"
+}
+
+func (hc *TCPHealthCheck) String() string {
+	return fmt.Sprintf("tcp check on port %s", hc.Port)
+}
+
+func (hc *HTTPHealthCheck) String() string {
+	return fmt.Sprintf("http check of %s on port %s", hc.Path, hc.Port)
+}
+
+func (hc *LogHealthCheck) String() string {
+	return fmt.Sprintf("log check for %q", hc.Pattern)
+}
+
+func (hc *DockerHealthCheck) Healthy(ctx context.Context, c *Container) (bool, error) {
+	inspect, err := c.Client.ContainerInspect(ctx, c.Id)
+	if err != nil {
+		return false, fmt.Errorf("DockerHealthCheck.Healthy: %w", err)
+	}
+
+	if inspect.State.Health == nil {
+		return false, fmt.Errorf("DockerHealthCheck.Healthy: %w", &ContainerNotReadyError{
+			name:   c.Name,
+			reason: "no HEALTHCHECK is configured",
+		})
+	}
+
+	return inspect.State.Health.Status == types.Healthy, nil
+}
+
+func (hc *TCPHealthCheck) Healthy(ctx context.Context, c *Container) (bool, error) {
+	address, err := c.address(ctx, hc.Port)
+	if err != nil {
+		return false, fmt.Errorf("TCPHealthCheck.Healthy: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false, nil
+	}
+	conn.Close()
+
+	return true, nil
+}
+
+func (hc *HTTPHealthCheck) Healthy(ctx context.Context, c *Container) (bool, error) {
+	address, err := c.address(ctx, hc.Port)
+	if err != nil {
+		return false, fmt.Errorf("HTTPHealthCheck.Healthy: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s%s", address, hc.Path), nil)
+	if err != nil {
+		return false, fmt.Errorf("HTTPHealthCheck.Healthy: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, nil
+	}
+	defer res.Body.Close()
+
+	return res.StatusCode < http.StatusBadRequest, nil
+}
+
+func (hc *LogHealthCheck) Healthy(ctx context.Context, c *Container) (bool, error) {
+	logs, err := c.Client.ContainerLogs(ctx, c.Id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		return false, fmt.Errorf("LogHealthCheck.Healthy: %w", err)
+	}
+	defer logs.Close()
+
+	output := &bytes.Buffer{}
+	_, err = stdcopy.StdCopy(output, output, logs)
+	if err != nil {
+		return false, fmt.Errorf("LogHealthCheck.Healthy: %w", err)
+	}
+
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if hc.Pattern.Match(scanner.Bytes()) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//address returns where port can be reached from the host, the published host port when there is one and the
+//container's own address otherwise
+func (c *Container) address(ctx context.Context, port string) (string, error) {
+	if hostPort, ok := c.Ports[port]; ok && hostPort != "" {
+		return net.JoinHostPort("127.0.0.1", hostPort), nil
+	}
+
+	inspect, err := c.Client.ContainerInspect(ctx, c.Id)
+	if err != nil {
+		return "", fmt.Errorf("Container.address: %w", err)
+	}
+
+	for _, settings := range inspect.NetworkSettings.Networks {
+		if settings.IPAddress != "" {
+			return net.JoinHostPort(settings.IPAddress, port), nil
+		}
+	}
+
+	return "", fmt.Errorf("Container.address: %w", &ContainerNotReadyError{
+		name:   c.Name,
+		reason: "container has no address",
+	})
+}
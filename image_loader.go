@@ -0,0 +1,147 @@
+package kubby
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/docker/docker/client"
+	"sigs.k8s.io/kind/pkg/cluster"
+	"sigs.k8s.io/kind/pkg/cluster/nodeutils"
+)
+
+//NodeImageLoader is an ImageRegister that loads images straight into the containerd of every kind node instead of
+//pushing them to a registry, the equivalent of kind load docker-image. Loaded images live and die with the nodes.
+//No registry answers on the image prefix, so images must not be tagged latest or left untagged: Kubernetes defaults
+//those to imagePullPolicy Always and the pull fails. Use a fixed tag or set the pull policy to IfNotPresent or Never
+type NodeImageLoader struct {
+	Client      *client.Client
+	Provider    *cluster.Provider
+	ClusterName string
+	Prefix      string
+}
+
+//NewNodeImageLoader creates a loader for the named cluster. Images built with BuildAndPushImage are tagged with
+//prefix so pods reference them exactly as they would with a ClusterRegistry
+func NewNodeImageLoader(provider *cluster.Provider, clusterName string, prefix string) (*NodeImageLoader, error) {
+	cli, err := NewContainerClient()
+	if err != nil {
+		return nil, fmt.Errorf("NewNodeImageLoader: %w", err)
+	}
+
+	loader := &NodeImageLoader{
+		Client:      cli,
+		Provider:    provider,
+		ClusterName: clusterName,
+		Prefix:      prefix,
+	}
+
+	return loader, nil
+}
+
+func (l *NodeImageLoader) BuildAndPushImage(ctx context.Context, dockerPath string, name string, options ...BuildOption) (*PushedImage, error) {
+	image := fmt.Sprintf("%s/%s", l.Prefix, name)
+
+	fmt.Printf("building %s...\n", image)
+	err := buildImage(ctx, l.Client, dockerPath, image, options...)
+	if err != nil {
+		return nil, fmt.Errorf("NodeImageLoader.BuildAndPushImage: %w", err)
+	}
+
+	pushed, err := l.PushImage(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("NodeImageLoader.BuildAndPushImage: %w", err)
+	}
+
+	return pushed, nil
+}
+
+//PushImage saves the local image and imports it into every node. Loaded images have no registry manifest to pin,
+//so the returned digest is empty and both references are the image name itself
+func (l *NodeImageLoader) PushImage(ctx context.Context, image string) (*PushedImage, error) {
+	fmt.Printf("loading %s into nodes...\n", image)
+
+	//nothing serves the prefix, so a pod defaulting to imagePullPolicy Always would end up in ImagePullBackOff
+	if imageTag(image) == "latest" {
+		fmt.Printf("warning: %s is tagged latest, pods using it need imagePullPolicy IfNotPresent or Never\n", image)
+	}
+
+	_, _, err := l.Client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("NodeImageLoader.PushImage: %w", err)
+	}
+
+	archive, err := ioutil.TempFile("", "kubby-image-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("NodeImageLoader.PushImage: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	saved, err := l.Client.ImageSave(ctx, []string{image})
+	if err != nil {
+		return nil, fmt.Errorf("NodeImageLoader.PushImage: %w", err)
+	}
+	defer saved.Close()
+
+	_, err = io.Copy(archive, saved)
+	if err != nil {
+		return nil, fmt.Errorf("NodeImageLoader.PushImage: %w", err)
+	}
+
+	err = l.LoadImageArchive(ctx, archive.Name())
+	if err != nil {
+		return nil, fmt.Errorf("NodeImageLoader.PushImage: %w", err)
+	}
+
+	pushed := &PushedImage{
+		Reference:        image,
+		ClusterReference: image,
+	}
+
+	return pushed, nil
+}
+
+//LoadImageArchive imports a docker save tarball into every node, the equivalent of kind load image-archive
+func (l *NodeImageLoader) LoadImageArchive(ctx context.Context, path string) error {
+	nodes, err := l.Provider.ListInternalNodes(l.ClusterName)
+	if err != nil {
+		return fmt.Errorf("NodeImageLoader.LoadImageArchive: %w", err)
+	}
+
+	for _, node := range nodes {
+		if ctx.Err() != nil {
+			return fmt.Errorf("NodeImageLoader.LoadImageArchive: %w", ctx.Err())
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("NodeImageLoader.LoadImageArchive: %w", err)
+		}
+
+		err = nodeutils.LoadImageArchive(node, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("NodeImageLoader.LoadImageArchive: %s: %w", node.String(), err)
+		}
+	}
+
+	return nil
+}
+
+//Acquire is a no-op
+func (l *NodeImageLoader) Acquire(ctx context.Context, owner string) error {
+	return nil
+}
+
+//Release is a no-op
+func (l *NodeImageLoader) Release(ctx context.Context, owner string) error {
+	return nil
+}
+
+//Delete is a no-op
+func (l *NodeImageLoader) Delete(ctx context.Context) error {
+	return nil
+}
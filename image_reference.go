@@ -0,0 +1,59 @@
+package kubby
+
+import (
+	"fmt"
+	"strings"
+)
+
+//PushedImage describes an image made available to the cluster. Digest pins the exact artifact so tests aren't
+//affected by stale tags cached by containerd on the nodes
+type PushedImage struct {
+	Reference        string
+	Digest           string
+	ClusterReference string
+}
+
+//Pinned returns the host reference pinned to the pushed digest
+func (image *PushedImage) Pinned() string {
+	return pinReference(image.Reference, image.Digest)
+}
+
+type pushAux struct {
+	Tag    string `json:"Tag"`
+	Digest string `json:"Digest"`
+	Size   int    `json:"Size"`
+}
+
+//repositoryName strips any tag or digest from image, keeping a registry host's port intact
+func repositoryName(image string) string {
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	return image
+}
+
+//imageTag returns image's tag, latest when it has neither a tag nor a digest
+func imageTag(image string) string {
+	if strings.Contains(image, "@") {
+		return ""
+	}
+
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+
+	return "latest"
+}
+
+func pinReference(image string, digest string) string {
+	if digest == "" {
+		return image
+	}
+
+	return fmt.Sprintf("%s@%s", repositoryName(image), digest)
+}
@@ -0,0 +1,49 @@
+package kubby
+
+import "testing"
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"alpine", "latest"},
+		{"alpine:3.15", "3.15"},
+		{"localhost:5000/app", "latest"},
+		{"localhost:5000/app:v1", "v1"},
+		{"example.com/team/app@sha256:abc", ""},
+		{"example.com/team/app:v1@sha256:abc", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			got := imageTag(test.image)
+			if got != test.want {
+				t.Errorf("imageTag(%q) = %q, want %q", test.image, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPinReference(t *testing.T) {
+	tests := []struct {
+		image  string
+		digest string
+		want   string
+	}{
+		{"alpine:3.15", "", "alpine:3.15"},
+		{"alpine:3.15", "sha256:abc", "alpine@sha256:abc"},
+		{"localhost:5000/app", "sha256:abc", "localhost:5000/app@sha256:abc"},
+		{"localhost:5000/app:v1", "sha256:abc", "localhost:5000/app@sha256:abc"},
+		{"localhost:5000/app@sha256:old", "sha256:abc", "localhost:5000/app@sha256:abc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image+"@"+test.digest, func(t *testing.T) {
+			got := pinReference(test.image, test.digest)
+			if got != test.want {
+				t.Errorf("pinReference(%q, %q) = %q, want %q", test.image, test.digest, got, test.want)
+			}
+		})
+	}
+}
@@ -14,6 +14,14 @@ type KindConfig struct {
 	NodePorts         []*NodePort
 	RegistryAddress   string
 	RegistryPort      string
+	RegistryCAFile    string
+	Mirrors           []*KindMirror
+}
+
+//KindMirror points containerd at Endpoint when pulling images hosted on Host
+type KindMirror struct {
+	Host     string
+	Endpoint string
 }
 
 func NewKindConfig(name string, cnCount int, wnCount int, np []*NodePort, ra string, rp string) *KindConfig {
@@ -29,6 +37,8 @@ func NewKindConfig(name string, cnCount int, wnCount int, np []*NodePort, ra str
 	return config
 }
 
+const nodeRegistryCAPath = "/etc/kubby/registry-ca.crt"
+
 var (
 	kindHeaderFormat = `
 kind: Cluster
@@ -37,18 +47,42 @@ name: %s
 containerdConfigPatches:
 - |-
   [plugins."io.containerd.grpc.v1.cri".registry.mirrors."localhost:%s"]
-    endpoint = ["http://%s:%s"]
-nodes:`
+    endpoint = ["%s://%s:%s"]`
+	registryTLSFormat = `
+  [plugins."io.containerd.grpc.v1.cri".registry.configs."%s:%s".tls]
+    ca_file = "%s"`
+	mirrorFormat = `
+  [plugins."io.containerd.grpc.v1.cri".registry.mirrors."%s"]
+    endpoint = ["%s"]`
 	portMappingFormat = `
   - containerPort: %s
     hostPort: %s`
+	extraMountFormat = `
+  extraMounts:
+  - hostPath: %s
+    containerPath: %s
+    readOnly: true`
 )
 
 func (config *KindConfig) String() string {
-	kindConfig := fmt.Sprintf(kindHeaderFormat, config.Name, config.RegistryPort, config.RegistryAddress, config.RegistryPort)
+	scheme := "http"
+	if config.RegistryCAFile != "" {
+		scheme = "https"
+	}
+
+	kindConfig := fmt.Sprintf(kindHeaderFormat, config.Name, config.RegistryPort, scheme, config.RegistryAddress, config.RegistryPort)
+
+	if config.RegistryCAFile != "" {
+		kindConfig = kindConfig + fmt.Sprintf(registryTLSFormat, config.RegistryAddress, config.RegistryPort, nodeRegistryCAPath)
+	}
+
+	for _, mirror := range config.Mirrors {
+		kindConfig = kindConfig + fmt.Sprintf(mirrorFormat, mirror.Host, mirror.Endpoint)
+	}
 
 	kindConfig = kindConfig + `
-- role: control-plane
+nodes:
+- role: control-plane` + config.nodeMounts() + `
   extraPortMappings:`
 
 	for _, port := range config.NodePorts {
@@ -57,13 +91,22 @@ func (config *KindConfig) String() string {
 
 	for i := 1; i < config.ControlPlaneNodes; i++ {
 		kindConfig = kindConfig + `
-- role: control-plane`
+- role: control-plane` + config.nodeMounts()
 	}
 
 	for i := 0; i < config.WorkerNodes; i++ {
 		kindConfig = kindConfig + `
-- role: worker`
+- role: worker` + config.nodeMounts()
 	}
 
 	return kindConfig
 }
+
+//nodeMounts mounts the registry CA into a node so containerd can verify the registry's certificate
+func (config *KindConfig) nodeMounts() string {
+	if config.RegistryCAFile == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(extraMountFormat, config.RegistryCAFile, nodeRegistryCAPath)
+}
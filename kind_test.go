@@ -0,0 +1,136 @@
+package kubby
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
+	"sigs.k8s.io/yaml"
+)
+
+func TestKindConfigString(t *testing.T) {
+	ports := []*NodePort{{Host: "8080", Container: "30080"}}
+
+	tests := []struct {
+		name    string
+		config  *KindConfig
+		patches []string
+		absent  []string
+		mounted bool
+	}{
+		{
+			name:   "plain registry",
+			config: NewKindConfig("plain", 1, 2, ports, "kind-registry", "5000"),
+			patches: []string{
+				`[plugins."io.containerd.grpc.v1.cri".registry.mirrors."localhost:5000"]`,
+				`endpoint = ["http://kind-registry:5000"]`,
+			},
+			absent: []string{"tls", "ca_file"},
+		},
+		{
+			name: "tls registry",
+			config: &KindConfig{
+				Name:              "tls",
+				ControlPlaneNodes: 3,
+				WorkerNodes:       1,
+				NodePorts:         ports,
+				RegistryAddress:   "kind-registry",
+				RegistryPort:      "5000",
+				RegistryCAFile:    "/tmp/certs/ca.crt",
+			},
+			patches: []string{
+				`endpoint = ["https://kind-registry:5000"]`,
+				`[plugins."io.containerd.grpc.v1.cri".registry.configs."kind-registry:5000".tls]`,
+				`ca_file = "` + nodeRegistryCAPath + `"`,
+			},
+			absent:  []string{"http://"},
+			mounted: true,
+		},
+		{
+			name: "mirrors",
+			config: &KindConfig{
+				Name:              "mirrors",
+				ControlPlaneNodes: 1,
+				RegistryAddress:   "kind-registry",
+				RegistryPort:      "5000",
+				Mirrors: []*KindMirror{
+					{Host: "docker.io", Endpoint: "http://kind-mirror-docker-io:5000"},
+					{Host: "quay.io", Endpoint: "http://kind-mirror-quay-io:5000"},
+				},
+			},
+			patches: []string{
+				`[plugins."io.containerd.grpc.v1.cri".registry.mirrors."localhost:5000"]`,
+				`[plugins."io.containerd.grpc.v1.cri".registry.mirrors."docker.io"]`,
+				`endpoint = ["http://kind-mirror-docker-io:5000"]`,
+				`[plugins."io.containerd.grpc.v1.cri".registry.mirrors."quay.io"]`,
+				`endpoint = ["http://kind-mirror-quay-io:5000"]`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := &v1alpha4.Cluster{}
+			err := yaml.UnmarshalStrict([]byte(test.config.String()), cluster)
+			if err != nil {
+				t.Fatalf("config isn't valid kind yaml: %v\n%s", err, test.config)
+			}
+
+			if cluster.Name != test.config.Name {
+				t.Errorf("name is %q, want %q", cluster.Name, test.config.Name)
+			}
+
+			if len(cluster.ContainerdConfigPatches) != 1 {
+				t.Fatalf("got %d containerd patches, want 1", len(cluster.ContainerdConfigPatches))
+			}
+			patch := cluster.ContainerdConfigPatches[0]
+
+			for _, want := range test.patches {
+				if !strings.Contains(patch, want) {
+					t.Errorf("containerd patch is missing %s:\n%s", want, patch)
+				}
+			}
+
+			for _, unwanted := range test.absent {
+				if strings.Contains(patch, unwanted) {
+					t.Errorf("containerd patch shouldn't contain %s:\n%s", unwanted, patch)
+				}
+			}
+
+			controlPlanes, workers := 0, 0
+			for i, node := range cluster.Nodes {
+				switch node.Role {
+				case v1alpha4.ControlPlaneRole:
+					controlPlanes++
+				case v1alpha4.WorkerRole:
+					workers++
+				}
+
+				if i == 0 && len(node.ExtraPortMappings) != len(test.config.NodePorts) {
+					t.Errorf("first node maps %d ports, want %d", len(node.ExtraPortMappings), len(test.config.NodePorts))
+				}
+
+				if !test.mounted {
+					if len(node.ExtraMounts) != 0 {
+						t.Errorf("node %d has mounts without a registry CA", i)
+					}
+
+					continue
+				}
+
+				if len(node.ExtraMounts) != 1 {
+					t.Fatalf("node %d has %d mounts, want the registry CA", i, len(node.ExtraMounts))
+				}
+
+				mount := node.ExtraMounts[0]
+				if mount.HostPath != test.config.RegistryCAFile || mount.ContainerPath != nodeRegistryCAPath || !mount.Readonly {
+					t.Errorf("node %d mounts %+v, want the registry CA read only at %s", i, mount, nodeRegistryCAPath)
+				}
+			}
+
+			if controlPlanes != test.config.ControlPlaneNodes || workers != test.config.WorkerNodes {
+				t.Errorf("got %d control planes and %d workers, want %d and %d", controlPlanes, workers, test.config.ControlPlaneNodes, test.config.WorkerNodes)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package kubby
+
+import (
+	"context"
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	localRegistryHostingName      = "local-registry-hosting"
+	localRegistryHostingNamespace = "kube-public"
+	localRegistryHostingKey       = "localRegistryHosting.v1"
+	localRegistryHostingHelp      = "https://kind.sigs.k8s.io/docs/user/local-registry/"
+)
+
+//ClusterImagePrefix is the registry prefix pods inside the cluster use to pull images pushed to the kubby registry
+func (kc *KubeCluster) ClusterImagePrefix() string {
+	return fmt.Sprintf("localhost:%d", kc.RegistryPort)
+}
+
+//HostImagePrefix is the registry prefix used to push images from the host
+func (kc *KubeCluster) HostImagePrefix() string {
+	return fmt.Sprintf("127.0.0.1:%d", kc.RegistryPort)
+}
+
+//PublishRegistryHosting creates or updates the local-registry-hosting ConfigMap described by KEP-1755 so that tools
+//such as Tilt and Skaffold can discover the kubby registry
+func (kc *KubeCluster) PublishRegistryHosting(ctx context.Context) error {
+	client := kc.KubeClient.CoreV1().ConfigMaps(localRegistryHostingNamespace)
+
+	configMap := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      localRegistryHostingName,
+			Namespace: localRegistryHostingNamespace,
+		},
+		Data: map[string]string{
+			localRegistryHostingKey: fmt.Sprintf("host: %q\nhostFromContainerRuntime: %q\nhelp: %q\n",
+				kc.ClusterImagePrefix(),
+				fmt.Sprintf("%s:%d", kc.RegistryName, kc.RegistryPort),
+				localRegistryHostingHelp,
+			),
+		},
+	}
+
+	_, err := client.Create(ctx, configMap, metav1.CreateOptions{})
+	if err == nil {
+		return nil
+	}
+	if !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("KubeCluster.PublishRegistryHosting: %w", err)
+	}
+
+	_, err = client.Update(ctx, configMap, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("KubeCluster.PublishRegistryHosting: %w", err)
+	}
+
+	return nil
+}
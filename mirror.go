@@ -0,0 +1,49 @@
+package kubby
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const mirrorImagePort = "5000"
+
+//MirrorConfig describes a pull through cache started alongside the cluster for images hosted on Host
+type MirrorConfig struct {
+	Host     string
+	Upstream string
+	Name     string
+	Port     int
+	Options  []RegistryOption
+}
+
+//NewMirrorRegistry starts, or reuses, a registry proxying upstream. Cached layers are kept in a named volume so
+//they outlive the container and later clusters pull from the cache instead of upstream
+func NewMirrorRegistry(ctx context.Context, name string, hostPort string, upstream string, options ...RegistryOption) (*ClusterRegistry, error) {
+	options = append([]RegistryOption{
+		WithProxy(upstream),
+		WithStorageVolume(fmt.Sprintf("%s-cache", name)),
+	}, options...)
+
+	registry, err := NewRegistry(ctx, name, hostPort, mirrorImagePort, options...)
+	if err != nil {
+		return nil, fmt.Errorf("NewMirrorRegistry: %w", err)
+	}
+
+	return registry, nil
+}
+
+//KindMirror returns the containerd mirror entry sending pulls for the mirror's host to its container on the kind network
+func (mc *MirrorConfig) KindMirror() *KindMirror {
+	return &KindMirror{
+		Host:     mc.Host,
+		Endpoint: fmt.Sprintf("http://%s:%s", mc.Name, mirrorImagePort),
+	}
+}
+
+//mirrorName derives a container name from the mirrored host, e.g. docker.io becomes kind-mirror-docker-io
+func mirrorName(host string) string {
+	name := strings.NewReplacer(".", "-", ":", "-", "/", "-").Replace(host)
+
+	return fmt.Sprintf("kind-mirror-%s", name)
+}
@@ -0,0 +1,186 @@
+package kubby
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+type NamespaceConfig struct {
+	Labels       map[string]string
+	Annotations  map[string]string
+	IgnoreExists bool
+}
+
+type NamespaceOption func(nc *NamespaceConfig)
+
+func WithNamespaceLabels(labels map[string]string) NamespaceOption {
+	return func(nc *NamespaceConfig) {
+		for k, v := range labels {
+			nc.Labels[k] = v
+		}
+	}
+}
+
+func WithNamespaceAnnotations(annotations map[string]string) NamespaceOption {
+	return func(nc *NamespaceConfig) {
+		for k, v := range annotations {
+			nc.Annotations[k] = v
+		}
+	}
+}
+
+//ShouldIgnoreExistingNamespace makes CreateNamespace succeed when the namespace already exists. Labels and
+//annotations are still merged into the existing namespace
+func ShouldIgnoreExistingNamespace(ignore bool) NamespaceOption {
+	return func(nc *NamespaceConfig) {
+		nc.IgnoreExists = ignore
+	}
+}
+
+func (manager *KubeResourceManager) CreateNamespace(ctx context.Context, name string, options ...NamespaceOption) error {
+	fmt.Printf("creating namespace %s ...\n", name)
+
+	config := &NamespaceConfig{
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	client := manager.Client.CoreV1().Namespaces()
+
+	_, err := client.Create(ctx, &apiv1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      config.Labels,
+			Annotations: config.Annotations,
+		},
+	}, metav1.CreateOptions{})
+	if err == nil {
+		return nil
+	}
+	if !k8serrors.IsAlreadyExists(err) || !config.IgnoreExists {
+		return fmt.Errorf("CreateNamespace: %w", err)
+	}
+
+	if len(config.Labels) == 0 && len(config.Annotations) == 0 {
+		return nil
+	}
+
+	ns, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("CreateNamespace: %w", err)
+	}
+
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	for k, v := range config.Labels {
+		ns.Labels[k] = v
+	}
+
+	if ns.Annotations == nil {
+		ns.Annotations = map[string]string{}
+	}
+	for k, v := range config.Annotations {
+		ns.Annotations[k] = v
+	}
+
+	_, err = client.Update(ctx, ns, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("CreateNamespace: %w", err)
+	}
+
+	return nil
+}
+
+//DeleteNamespace deletes the namespace. If waitForDeletion is true it blocks until the namespace is fully removed and
+//returns a StuckNamespaceError listing the remaining finalizers if ctx ends first
+func (manager *KubeResourceManager) DeleteNamespace(ctx context.Context, name string, waitForDeletion bool) error {
+	fmt.Printf("deleting namespace %s ...\n", name)
+
+	client := manager.Client.CoreV1().Namespaces()
+
+	err := client.Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("DeleteNamespace: %w", err)
+	}
+
+	if !waitForDeletion {
+		return nil
+	}
+
+	err = waitForNamespaceDeletion(ctx, manager, name, time.Second)
+	if err != nil {
+		return fmt.Errorf("DeleteNamespace: %w", err)
+	}
+
+	return nil
+}
+
+func waitForNamespaceDeletion(ctx context.Context, manager *KubeResourceManager, name string, interval time.Duration) error {
+	client := manager.Client.CoreV1().Namespaces()
+	var last *apiv1.Namespace
+
+	err := wait.PollImmediateUntil(interval, func() (bool, error) {
+		ns, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				return true, nil
+			}
+
+			return false, err
+		}
+
+		last = ns
+		return false, nil
+	}, ctx.Done())
+	//the deadline can also surface as the context error from Get, the finalizers are reported either way
+	if err != nil && (err == wait.ErrWaitTimeout || ctx.Err() != nil) && last != nil {
+		return fmt.Errorf("waitForNamespaceDeletion: %w", newStuckNamespaceError(last))
+	}
+	if err != nil {
+		return fmt.Errorf("waitForNamespaceDeletion: %w", err)
+	}
+
+	return nil
+}
+
+func newStuckNamespaceError(ns *apiv1.Namespace) *StuckNamespaceError {
+	finalizers := []string{}
+	for _, f := range ns.Spec.Finalizers {
+		finalizers = append(finalizers, string(f))
+	}
+	finalizers = append(finalizers, ns.Finalizers...)
+
+	conditions := []string{}
+	for _, cond := range ns.Status.Conditions {
+		if cond.Status != apiv1.ConditionTrue {
+			continue
+		}
+
+		switch cond.Type {
+		case apiv1.NamespaceFinalizersRemaining, apiv1.NamespaceContentRemaining, apiv1.NamespaceDeletionContentFailure:
+			conditions = append(conditions, cond.Message)
+		}
+	}
+
+	return &StuckNamespaceError{
+		name:       ns.Name,
+		finalizers: finalizers,
+		details:    strings.Join(conditions, "; "),
+	}
+}
@@ -0,0 +1,245 @@
+package kubby
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+const (
+	kindNetwork    = "kind"
+	kindNetworkEnv = "KIND_EXPERIMENTAL_DOCKER_NETWORK"
+	networkLabel   = "io.platform-edn.kubby.network"
+	masqueradeOpt  = "com.docker.network.bridge.enable_ip_masquerade"
+	mtuOpt         = "com.docker.network.driver.mtu"
+	subnetAttempts = 5
+)
+
+//CreateNetwork creates a bridge network named name unless one already exists and returns its id. Kind skips
+//creating a network that already exists, so it is created the way kind would create it: IP masquerading, the MTU
+//of docker's default bridge and an IPv6 ULA subnet derived from the name, falling back to IPv4 only where the
+//daemon has no IPv6 support
+func CreateNetwork(ctx context.Context, cli *client.Client, name string) (string, error) {
+	id, err := findNetwork(ctx, cli, name)
+	if err != nil {
+		return "", fmt.Errorf("CreateNetwork: %w", err)
+	}
+
+	if id != "" {
+		return id, nil
+	}
+
+	options := map[string]string{
+		masqueradeOpt: "true",
+	}
+
+	bridge, err := cli.NetworkInspect(ctx, "bridge", types.NetworkInspectOptions{})
+	if err == nil && bridge.Options[mtuOpt] != "" {
+		options[mtuOpt] = bridge.Options[mtuOpt]
+	}
+
+	for attempt := int32(0); attempt < subnetAttempts; attempt++ {
+		id, err = createNetwork(ctx, cli, name, options, ulaSubnet(name, attempt))
+		if err == nil {
+			return id, nil
+		}
+
+		if strings.Contains(err.Error(), "Cannot read IPv6 setup for bridge") {
+			break
+		}
+
+		if !strings.Contains(err.Error(), "Pool overlaps") {
+			return "", fmt.Errorf("CreateNetwork: %w", err)
+		}
+	}
+
+	id, err = createNetwork(ctx, cli, name, options, "")
+	if err != nil {
+		return "", fmt.Errorf("CreateNetwork: %w", err)
+	}
+
+	return id, nil
+}
+
+//createNetwork creates the network, with IPv6 enabled when ipv6Subnet is set. Docker still assigns an IPv4 subnet
+//itself. A network created concurrently by someone else, e.g. kind, is returned instead of an error
+func createNetwork(ctx context.Context, cli *client.Client, name string, options map[string]string, ipv6Subnet string) (string, error) {
+	create := types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Options:        options,
+		Labels: map[string]string{
+			networkLabel: name,
+		},
+	}
+
+	if ipv6Subnet != "" {
+		create.EnableIPv6 = true
+		create.IPAM = &network.IPAM{
+			Config: []network.IPAMConfig{{Subnet: ipv6Subnet}},
+		}
+	}
+
+	res, err := cli.NetworkCreate(ctx, name, create)
+	if err != nil {
+		id, findErr := findNetwork(ctx, cli, name)
+		if findErr == nil && id != "" {
+			return id, nil
+		}
+
+		return "", fmt.Errorf("createNetwork: %w", err)
+	}
+
+	return res.ID, nil
+}
+
+//ulaSubnet derives a /64 in fc00::/8 from the network name the same way kind does, so a network created here gets
+//the subnet kind would have picked
+func ulaSubnet(name string, attempt int32) string {
+	ip := make([]byte, net.IPv6len)
+	ip[0] = 0xfc
+	ip[1] = 0x00
+
+	h := sha1.New()
+	h.Write([]byte(name))
+	binary.Write(h, binary.LittleEndian, attempt)
+	sum := h.Sum(nil)
+	copy(ip[2:8], sum[2:8])
+
+	subnet := &net.IPNet{
+		IP:   net.IP(ip),
+		Mask: net.CIDRMask(64, 128),
+	}
+
+	return subnet.String()
+}
+
+//RemoveNetwork removes the named network if it exists and no containers are attached to it anymore. It reports
+//whether the network is gone
+func RemoveNetwork(ctx context.Context, cli *client.Client, name string) (bool, error) {
+	inspect, err := cli.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return true, nil
+		}
+
+		return false, fmt.Errorf("RemoveNetwork: %w", err)
+	}
+
+	if len(inspect.Containers) != 0 {
+		return false, nil
+	}
+
+	err = cli.NetworkRemove(ctx, inspect.ID)
+	if err != nil && !client.IsErrNotFound(err) {
+		return false, fmt.Errorf("RemoveNetwork: %w", err)
+	}
+
+	return true, nil
+}
+
+//findNetwork returns the id of the network named exactly name, or an empty id when there is none. Docker's name
+//filter matches substrings so the result is compared again
+func findNetwork(ctx context.Context, cli *client.Client, name string) (string, error) {
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{
+		Filters: filters.NewArgs(filters.Arg("name", name)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("findNetwork: %w", err)
+	}
+
+	for _, nw := range networks {
+		if nw.Name == name {
+			return nw.ID, nil
+		}
+	}
+
+	return "", nil
+}
+
+//ConnectNetwork attaches the container to the named network, reachable there under its container name
+func (c *Container) ConnectNetwork(ctx context.Context, name string) error {
+	err := c.Client.NetworkConnect(ctx, name, c.Id, &network.EndpointSettings{
+		Aliases: []string{c.Name},
+	})
+	if err != nil {
+		return fmt.Errorf("Container.ConnectNetwork: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Container) DisconnectNetwork(ctx context.Context, name string) error {
+	err := c.Client.NetworkDisconnect(ctx, name, c.Id, false)
+	if err != nil {
+		return fmt.Errorf("Container.DisconnectNetwork: %w", err)
+	}
+
+	return nil
+}
+
+//ensureNetworks creates any of the container's networks that don't exist yet so Start doesn't depend on something
+//else, such as kind, having created them first
+func (c *Container) ensureNetworks(ctx context.Context) error {
+	for _, nw := range c.Networks {
+		_, err := CreateNetwork(ctx, c.Client, nw)
+		if err != nil {
+			return fmt.Errorf("Container.ensureNetworks: %w", err)
+		}
+	}
+
+	return nil
+}
+
+//kindNetworkLock serializes cluster creation in the process because kind reads its network from the environment
+var kindNetworkLock sync.Mutex
+
+//WithDockerNetwork runs the cluster's nodes, registry and mirrors on the named network instead of kind's shared
+//one, isolating environments that run in parallel. Kind only reads the network from the environment so the
+//variable is set for the whole process while the cluster is created, and concurrent cluster creations within one
+//process are serialized
+func WithDockerNetwork(name string) KubeClusterOption {
+	return func(kc *KubeCluster) {
+		kc.Network = name
+	}
+}
+
+//WithRegistryNetwork attaches the registry to name instead of the kind network
+func WithRegistryNetwork(name string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Networks = []string{name}
+	}
+}
+
+//withKindNetwork runs create with kind pointed at the cluster's network. Creates on the default network take the
+//lock too so they can't observe another cluster's network in the environment
+func (kc *KubeCluster) withKindNetwork(create func() error) error {
+	kindNetworkLock.Lock()
+	defer kindNetworkLock.Unlock()
+
+	if kc.Network == kindNetwork {
+		return create()
+	}
+
+	previous, set := os.LookupEnv(kindNetworkEnv)
+	os.Setenv(kindNetworkEnv, kc.Network)
+	defer func() {
+		if set {
+			os.Setenv(kindNetworkEnv, previous)
+		} else {
+			os.Unsetenv(kindNetworkEnv)
+		}
+	}()
+
+	return create()
+}
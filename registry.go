@@ -1,24 +1,70 @@
 package kubby
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/archive"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	registryLabel    = "io.platform-edn.kubby.registry"
+	registryRefLabel = "io.platform-edn.kubby.registry-ref"
+	ownerLabel       = "io.platform-edn.kubby.owner"
+	managedLabel     = "io.platform-edn.kubby.managed"
+	schemeLabel      = "io.platform-edn.kubby.registry-scheme"
+	authLabel        = "io.platform-edn.kubby.registry-auth"
+	proxyLabel       = "io.platform-edn.kubby.registry-proxy"
+	hostPortLabel    = "io.platform-edn.kubby.registry-host-port"
+	storageLabel     = "io.platform-edn.kubby.registry-storage"
 )
 
 type ClusterRegistry struct {
 	Container
-	Url string
+	Url            string
+	ClusterUrl     string
+	Config         RegistryConfig
+	Scheme         string
+	HTTPClient     *http.Client
+	CertDir        string
+	DockerCertsDir string
+	Username       string
+	Password       string
+	ReadyTimeout   time.Duration
+	PushBackoff    wait.Backoff
+}
+
+type RegistryOption func(r *ClusterRegistry)
+
+//ErrorLine is the error message found at the end of a docker build or push stream
+//
+//Deprecated: build and push output is now decoded with jsonmessage by getDockerOutput
+type ErrorLine struct {
+	Error       string      `json:"error"`
+	ErrorDetail ErrorDetail `json:"errorDetail"`
+}
+
+//ErrorDetail carries the message of an ErrorLine
+//
+//Deprecated: build and push output is now decoded with jsonmessage by getDockerOutput
+type ErrorDetail struct {
+	Message string `json:"message"`
 }
 
-func NewRegistry(ctx context.Context, name string, hostPort string, imagePort string) (*ClusterRegistry, error) {
+func NewRegistry(ctx context.Context, name string, hostPort string, imagePort string, options ...RegistryOption) (*ClusterRegistry, error) {
 	cli, err := client.NewClientWithOpts()
 	if err != nil {
 		return nil, fmt.Errorf("NewRegistry: %w", err)
@@ -30,15 +76,81 @@ func NewRegistry(ctx context.Context, name string, hostPort string, imagePort st
 			Name:     name,
 			Image:    "registry",
 			Tag:      "2",
-			Networks: []string{"kind"},
+			Pull:     PullIfNotPresent,
+			Networks: []string{kindNetwork},
 			Ports: map[string]string{
 				imagePort: hostPort,
 			},
+			Labels: map[string]string{
+				registryLabel: name,
+				managedLabel:  "true",
+			},
+		},
+		Url:        fmt.Sprintf("127.0.0.1:%s", hostPort),
+		ClusterUrl: fmt.Sprintf("localhost:%s", hostPort),
+		Config: RegistryConfig{
+			Env: map[string]string{},
 		},
-		Url: fmt.Sprintf("127.0.0.1:%s", hostPort),
+		Scheme:       "http",
+		HTTPClient:   http.DefaultClient,
+		ReadyTimeout: time.Second * 30,
+		PushBackoff: wait.Backoff{
+			Steps:    6,
+			Duration: time.Second / 4,
+			Factor:   2,
+			Jitter:   0.1,
+		},
+	}
+
+	for _, option := range options {
+		option(&r)
+	}
+
+	r.Env = append(r.Env, r.Config.EnvVars()...)
+
+	if r.CertDir != "" {
+		err = r.enableTLS()
+		if err != nil {
+			return nil, fmt.Errorf("NewRegistry: %w", err)
+		}
+	}
+
+	if r.Username != "" {
+		err = r.enableAuth()
+		if err != nil {
+			return nil, fmt.Errorf("NewRegistry: %w", err)
+		}
+	}
+
+	err = prepareStorage(r.Mounts)
+	if err != nil {
+		return nil, fmt.Errorf("NewRegistry: %w", err)
+	}
+
+	for k, v := range r.configLabels(hostPort) {
+		r.Labels[k] = v
+	}
+
+	existing, err := findContainerByName(ctx, cli, name)
+	if err != nil {
+		return nil, fmt.Errorf("NewRegistry: %w", err)
+	}
+
+	if existing == nil {
+		err = r.Start(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("NewRegistry: %w", err)
+		}
+	} else {
+		fmt.Printf("reusing registry container %s\n", name)
+
+		err = r.reuse(ctx, existing)
+		if err != nil {
+			return nil, fmt.Errorf("NewRegistry: %w", err)
+		}
 	}
 
-	err = r.Start(ctx)
+	err = r.WaitReady(ctx, imagePort)
 	if err != nil {
 		return nil, fmt.Errorf("NewRegistry: %w", err)
 	}
@@ -46,128 +158,371 @@ func NewRegistry(ctx context.Context, name string, hostPort string, imagePort st
 	return &r, nil
 }
 
-func (r *ClusterRegistry) PushImage(ctx context.Context, image string) error {
-	fmt.Printf("pushing %s...\n", image)
-	//this is gross but connection is getting reset for some reason:  Get "http://127.0.0.1:5000/v2/": EOF
-	//probably could handle this better in the future
-	for i := 0; i < 3; i++ {
-		err := pushImage(ctx, r.Client, image)
+//configLabels records the settings a container is created with that can't be changed afterwards
+func (r *ClusterRegistry) configLabels(hostPort string) map[string]string {
+	storage := ""
+	for _, m := range r.Mounts {
+		if m.Target == registryStoragePath {
+			storage = m.Source
+		}
+	}
+
+	return map[string]string{
+		schemeLabel:   r.Scheme,
+		authLabel:     r.Username,
+		proxyLabel:    r.Config.ProxyRemoteURL,
+		hostPortLabel: hostPort,
+		storageLabel:  storage,
+	}
+}
+
+//reuse adopts an already existing registry container, starting it if it is stopped and connecting it to any of
+//the registry's networks it isn't attached to yet. A container created with different settings is rejected rather
+//than silently serving the old ones
+func (r *ClusterRegistry) reuse(ctx context.Context, existing *types.Container) error {
+	if existing.Labels[registryLabel] != r.Name {
+		return fmt.Errorf("ClusterRegistry.reuse: %w", &ForeignContainerError{
+			name: r.Name,
+		})
+	}
+
+	for _, label := range []string{schemeLabel, authLabel, proxyLabel, hostPortLabel, storageLabel} {
+		//containers created by older versions carry no config labels, there is nothing to compare them against
+		got, ok := existing.Labels[label]
+		if ok && got != r.Labels[label] {
+			return fmt.Errorf("ClusterRegistry.reuse: %w", &RegistryConfigMismatchError{
+				name:      r.Name,
+				label:     label,
+				existing:  got,
+				requested: r.Labels[label],
+			})
+		}
+	}
+
+	r.Id = existing.ID
+
+	if existing.State != "running" {
+		err := r.Client.ContainerStart(ctx, r.Id, types.ContainerStartOptions{})
 		if err != nil {
-			if i != 2 {
-				time.Sleep(time.Second / 2)
-				continue
-			}
-			return fmt.Errorf("ClusterRegistry.PushImage: %w", err)
+			return fmt.Errorf("ClusterRegistry.reuse: %w", err)
 		}
+	}
 
-		break
+	inspect, err := r.Client.ContainerInspect(ctx, r.Id)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.reuse: %w", err)
+	}
+
+	err = r.ensureNetworks(ctx)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.reuse: %w", err)
+	}
+
+	for _, nw := range r.Networks {
+		if _, ok := inspect.NetworkSettings.Networks[nw]; ok {
+			continue
+		}
+
+		err = r.ConnectNetwork(ctx, nw)
+		if err != nil {
+			return fmt.Errorf("ClusterRegistry.reuse: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func (r *ClusterRegistry) BuildAndPushImage(ctx context.Context, dockerPath string, name string) error {
-	image := fmt.Sprintf("%s/%s", r.Url, name)
+//Acquire records that owner uses the registry. References are kept as labelled docker volumes because labels on
+//the registry container itself cannot be changed once it is created
+func (r *ClusterRegistry) Acquire(ctx context.Context, owner string) error {
+	_, err := r.Client.VolumeCreate(ctx, volume.VolumeCreateBody{
+		Name: registryRefName(r.Name, owner),
+		Labels: map[string]string{
+			registryRefLabel: r.Name,
+			ownerLabel:       owner,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.Acquire: %w", err)
+	}
 
-	fmt.Printf("building %s...\n", image)
-	err := buildImage(ctx, r.Client, dockerPath, image)
+	return nil
+}
+
+//Release drops owner's reference to the registry and deletes the registry once no references remain
+func (r *ClusterRegistry) Release(ctx context.Context, owner string) error {
+	err := r.Client.VolumeRemove(ctx, registryRefName(r.Name, owner), true)
+	if err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("ClusterRegistry.Release: %w", err)
+	}
+
+	refs, err := r.References(ctx)
 	if err != nil {
-		return fmt.Errorf("ClusterRegistry.PushImage: %w", err)
+		return fmt.Errorf("ClusterRegistry.Release: %w", err)
 	}
 
-	fmt.Printf("pushing %s...\n", image)
-	//this is gross but connection is getting reset for some reason:  Get "http://127.0.0.1:5000/v2/": EOF
-	//probably could handle this better in the future
-	for i := 0; i < 3; i++ {
-		err := pushImage(ctx, r.Client, image)
-		if err != nil {
-			if i != 2 {
-				time.Sleep(time.Second / 2)
-				continue
+	if len(refs) != 0 {
+		fmt.Printf("registry %s is still used by %v\n", r.Name, refs)
+		return nil
+	}
+
+	err = r.Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.Release: %w", err)
+	}
+
+	return nil
+}
+
+//References returns the owners currently holding a reference to the registry
+func (r *ClusterRegistry) References(ctx context.Context) ([]string, error) {
+	volumes, err := r.Client.VolumeList(ctx, filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", registryRefLabel, r.Name))))
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.References: %w", err)
+	}
+
+	owners := []string{}
+	for _, v := range volumes.Volumes {
+		owners = append(owners, v.Labels[ownerLabel])
+	}
+
+	return owners, nil
+}
+
+//FindRegistries returns the names of every registry container created by kubby, running or not
+func FindRegistries(ctx context.Context, cli *client.Client) ([]string, error) {
+	containers, err := FindContainers(ctx, cli, map[string]string{
+		registryLabel: "",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("FindRegistries: %w", err)
+	}
+
+	names := []string{}
+	for _, c := range containers {
+		names = append(names, c.Labels[registryLabel])
+	}
+
+	return names, nil
+}
+
+func registryRefName(registry string, owner string) string {
+	return fmt.Sprintf("%s-ref-%s", registry, owner)
+}
+
+//findContainerByName returns the container named exactly name or nil when there is none. Docker's name filter is a
+//regular expression, so the name is quoted and the result compared again
+func findContainerByName(ctx context.Context, cli *client.Client, name string) (*types.Container, error) {
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("name", fmt.Sprintf("^/%s$", regexp.QuoteMeta(name)))),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("findContainerByName: %w", err)
+	}
+
+	for i, c := range containers {
+		for _, n := range c.Names {
+			if n == "/"+name {
+				return &containers[i], nil
 			}
-			return fmt.Errorf("ClusterRegistry.PushImage: %w", err)
 		}
+	}
+
+	return nil, nil
+}
+
+//WaitReady blocks until the registry's /v2/ endpoint answers both on the host port and, from inside the kind
+//network, on the registry's network alias
+func (r *ClusterRegistry) WaitReady(ctx context.Context, imagePort string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.ReadyTimeout)
+	defer cancel()
+
+	hostUrl := fmt.Sprintf("%s://%s/v2/", r.Scheme, r.Url)
+	networkUrl := fmt.Sprintf("%s://%s:%s/v2/", r.Scheme, r.Name, imagePort)
+
+	err := wait.PollImmediateUntil(time.Second/4, func() (bool, error) {
+		res, err := r.registryRequest(ctx, http.MethodGet, "/v2/", nil)
+		if err != nil {
+			return false, nil
+		}
+		defer res.Body.Close()
 
-		break
+		return res.StatusCode == http.StatusOK, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.WaitReady: %w", &RegistryNotReadyError{
+			url: hostUrl,
+		})
+	}
+
+	err = wait.PollImmediateUntil(time.Second/4, func() (bool, error) {
+		if r.Scheme == "https" || r.Username != "" {
+			//busybox wget in the registry image can't verify our CA or authenticate, so only check the port is reachable
+			return checkPortInNetwork(ctx, r.Client, r.Id, r.Name, imagePort), nil
+		}
+
+		return checkRegistryEndpointInNetwork(ctx, r.Client, r.Id, networkUrl), nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.WaitReady: %w", &RegistryNotReadyError{
+			url: networkUrl,
+		})
 	}
 
 	return nil
 }
 
-func buildImage(ctx context.Context, cli *client.Client, path string, image string) error {
-	tar, err := archive.TarWithOptions(path, &archive.TarOptions{})
+//PushImage pushes a local image to the registry and returns it pinned to the pushed digest
+func (r *ClusterRegistry) PushImage(ctx context.Context, image string) (*PushedImage, error) {
+	fmt.Printf("pushing %s...\n", image)
+
+	pushed, err := r.pushWithRetry(ctx, image)
 	if err != nil {
-		return fmt.Errorf("BuildImage: %w", err)
+		return nil, fmt.Errorf("ClusterRegistry.PushImage: %w", err)
 	}
 
-	opts := types.ImageBuildOptions{
-		Dockerfile: "Dockerfile",
-		Tags:       []string{image},
-		Remove:     true,
+	return pushed, nil
+}
+
+func (r *ClusterRegistry) BuildAndPushImage(ctx context.Context, dockerPath string, name string, options ...BuildOption) (*PushedImage, error) {
+	image := fmt.Sprintf("%s/%s", r.Url, name)
+
+	fmt.Printf("building %s...\n", image)
+	err := buildImage(ctx, r.Client, dockerPath, image, options...)
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.BuildAndPushImage: %w", err)
 	}
 
-	res, err := cli.ImageBuild(ctx, tar, opts)
+	fmt.Printf("pushing %s...\n", image)
+	pushed, err := r.pushWithRetry(ctx, image)
 	if err != nil {
-		return fmt.Errorf("BuildImage: %w", err)
+		return nil, fmt.Errorf("ClusterRegistry.BuildAndPushImage: %w", err)
 	}
 
-	defer res.Body.Close()
+	return pushed, nil
+}
+
+//pushWithRetry retries transient push failures using PushBackoff. Permanent failures are returned immediately
+func (r *ClusterRegistry) pushWithRetry(ctx context.Context, image string) (*PushedImage, error) {
+	var digest string
+
+	err := retry.OnError(r.PushBackoff, func(err error) bool {
+		return ctx.Err() == nil && isTransientPushError(err)
+	}, func() error {
+		auth, err := r.registryAuth()
+		if err != nil {
+			return err
+		}
 
-	err = getDockerOutput(res.Body)
+		d, err := pushImage(ctx, r.Client, image, auth)
+		digest = d
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("BuildImage: %w", err)
+		return nil, fmt.Errorf("pushWithRetry: %w", err)
 	}
 
-	return nil
+	clusterImage := image
+	if strings.HasPrefix(image, r.Url+"/") {
+		clusterImage = r.ClusterUrl + strings.TrimPrefix(image, r.Url)
+	}
+
+	pushed := &PushedImage{
+		Reference:        image,
+		Digest:           digest,
+		ClusterReference: pinReference(clusterImage, digest),
+	}
+
+	return pushed, nil
 }
 
-func pushImage(ctx context.Context, cli *client.Client, image string) error {
+//pushImage pushes image with the encoded registry auth and returns the digest the registry reported for it
+func pushImage(ctx context.Context, cli *client.Client, image string, auth string) (string, error) {
 	res, err := cli.ImagePush(ctx, image, types.ImagePushOptions{
-		RegistryAuth: "holder",
+		RegistryAuth: auth,
 	})
 	if err != nil {
-		return fmt.Errorf("pushImage: %w", err)
+		return "", fmt.Errorf("pushImage: %w", err)
 	}
 
 	defer res.Close()
 
-	err = getDockerOutput(res)
+	var digest string
+	err = getDockerOutput(res, nil, func(raw *json.RawMessage) error {
+		aux := pushAux{}
+		err := json.Unmarshal(*raw, &aux)
+		if err != nil {
+			return err
+		}
+
+		if aux.Digest != "" {
+			digest = aux.Digest
+		}
+
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("pushImage: %w", err)
+		return "", fmt.Errorf("pushImage: %w", err)
 	}
 
-	return nil
+	return digest, nil
 }
 
-type ErrorLine struct {
-	Error       string      `json:"error"`
-	ErrorDetail ErrorDetail `json:"errorDetail"`
+//checkRegistryEndpointInNetwork runs wget inside the registry container so the request resolves the registry's
+//alias through the network's DNS the same way the kind nodes do
+func checkRegistryEndpointInNetwork(ctx context.Context, cli *client.Client, id string, url string) bool {
+	code, _, err := execInContainer(ctx, cli, id, []string{"wget", "-q", "-O", "/dev/null", url})
+	if err != nil {
+		return false
+	}
+
+	return code == 0
 }
 
-type ErrorDetail struct {
-	Message string `json:"message"`
+func checkPortInNetwork(ctx context.Context, cli *client.Client, id string, host string, port string) bool {
+	code, _, err := execInContainer(ctx, cli, id, []string{"nc", "-z", "-w", "1", host, port})
+	if err != nil {
+		return false
+	}
+
+	return code == 0
 }
 
-func getDockerOutput(rd io.Reader) error {
-	var lastLine string
+var transientPushMessages = []string{
+	"EOF",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+}
 
-	scanner := bufio.NewScanner(rd)
-	for scanner.Scan() {
-		lastLine = scanner.Text()
+//isTransientPushError reports whether a push failure is worth retrying. Network level failures and gateway
+//errors are transient, anything else (unknown image, denied, invalid manifest) is permanent
+func isTransientPushError(err error) bool {
+	if err == nil {
+		return false
 	}
 
-	errLine := &ErrorLine{}
-	json.Unmarshal([]byte(lastLine), errLine)
-	if errLine.Error != "" {
-		return fmt.Errorf("CheckImageBuildOutput: %w", &BadImageBuildError{
-			output: errLine.Error,
-		})
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
 	}
 
-	err := scanner.Err()
-	if err != nil {
-		return fmt.Errorf("CheckImageBuildOutput: %w", err)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
 	}
 
-	return nil
+	msg := err.Error()
+	for _, transient := range transientPushMessages {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+
+	return false
 }
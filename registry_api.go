@@ -0,0 +1,237 @@
+package kubby
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+type Manifest struct {
+	MediaType string
+	Digest    string
+	Content   []byte
+}
+
+//ListRepositories returns every repository in the registry's catalog
+func (r *ClusterRegistry) ListRepositories(ctx context.Context) ([]string, error) {
+	repositories := []string{}
+	next := "/v2/_catalog?n=1000"
+
+	for next != "" {
+		catalog := struct {
+			Repositories []string `json:"repositories"`
+		}{}
+
+		res, err := r.registryRequest(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, fmt.Errorf("ClusterRegistry.ListRepositories: %w", err)
+		}
+
+		err = decodeRegistryResponse(res, &catalog)
+		if err != nil {
+			return nil, fmt.Errorf("ClusterRegistry.ListRepositories: %w", err)
+		}
+
+		repositories = append(repositories, catalog.Repositories...)
+		next = nextPage(res.Header.Get("Link"))
+	}
+
+	return repositories, nil
+}
+
+func (r *ClusterRegistry) ListTags(ctx context.Context, repository string) ([]string, error) {
+	tags := struct {
+		Tags []string `json:"tags"`
+	}{}
+
+	res, err := r.registryRequest(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/tags/list", repository), nil)
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.ListTags: %w", err)
+	}
+
+	err = decodeRegistryResponse(res, &tags)
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.ListTags: %w", err)
+	}
+
+	return tags.Tags, nil
+}
+
+//GetManifest fetches the manifest for reference, which may be a tag or a digest
+func (r *ClusterRegistry) GetManifest(ctx context.Context, repository string, reference string) (*Manifest, error) {
+	res, err := r.registryRequest(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), manifestHeaders())
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.GetManifest: %w", err)
+	}
+	defer res.Body.Close()
+
+	err = checkRegistryResponse(res)
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.GetManifest: %w", err)
+	}
+
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ClusterRegistry.GetManifest: %w", err)
+	}
+
+	manifest := &Manifest{
+		MediaType: res.Header.Get("Content-Type"),
+		Digest:    res.Header.Get("Docker-Content-Digest"),
+		Content:   content,
+	}
+
+	return manifest, nil
+}
+
+//GetDigest resolves reference to the digest of its manifest
+func (r *ClusterRegistry) GetDigest(ctx context.Context, repository string, reference string) (string, error) {
+	res, err := r.registryRequest(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), manifestHeaders())
+	if err != nil {
+		return "", fmt.Errorf("ClusterRegistry.GetDigest: %w", err)
+	}
+	defer res.Body.Close()
+
+	err = checkRegistryResponse(res)
+	if err != nil {
+		return "", fmt.Errorf("ClusterRegistry.GetDigest: %w", err)
+	}
+
+	return res.Header.Get("Docker-Content-Digest"), nil
+}
+
+//DeleteImage deletes the manifest reference points to. The registry must have been started WithDeleteEnabled and
+//the underlying blobs are only reclaimed by GarbageCollect
+func (r *ClusterRegistry) DeleteImage(ctx context.Context, repository string, reference string) error {
+	digest := reference
+	if !strings.HasPrefix(reference, "sha256:") {
+		d, err := r.GetDigest(ctx, repository, reference)
+		if err != nil {
+			return fmt.Errorf("ClusterRegistry.DeleteImage: %w", err)
+		}
+
+		digest = d
+	}
+
+	res, err := r.registryRequest(ctx, http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", repository, digest), nil)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.DeleteImage: %w", err)
+	}
+	defer res.Body.Close()
+
+	err = checkRegistryResponse(res)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.DeleteImage: %w", err)
+	}
+
+	return nil
+}
+
+//GarbageCollect runs the registry's garbage collector to remove blobs no longer referenced by any manifest
+func (r *ClusterRegistry) GarbageCollect(ctx context.Context) error {
+	cmd := []string{"registry", "garbage-collect", "--delete-untagged", "/etc/docker/registry/config.yml"}
+
+	code, out, err := execInContainer(ctx, r.Client, r.Id, cmd)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.GarbageCollect: %w", err)
+	}
+
+	if code != 0 {
+		return fmt.Errorf("ClusterRegistry.GarbageCollect: %w", &ContainerCommandError{
+			command:  strings.Join(cmd, " "),
+			exitCode: code,
+			output:   strings.TrimSpace(string(out)),
+		})
+	}
+
+	return nil
+}
+
+func (r *ClusterRegistry) registryRequest(ctx context.Context, method string, path string, headers http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s://%s%s", r.Scheme, r.Url, path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("registryRequest: %w", err)
+	}
+
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	res, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("registryRequest: %w", err)
+	}
+
+	return res, nil
+}
+
+func manifestHeaders() http.Header {
+	return http.Header{
+		"Accept": manifestMediaTypes,
+	}
+}
+
+func checkRegistryResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+
+	return &RegistryRequestError{
+		status:  res.StatusCode,
+		message: strings.TrimSpace(string(body)),
+	}
+}
+
+func decodeRegistryResponse(res *http.Response, v interface{}) error {
+	defer res.Body.Close()
+
+	err := checkRegistryResponse(res)
+	if err != nil {
+		return fmt.Errorf("decodeRegistryResponse: %w", err)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decodeRegistryResponse: %w", err)
+	}
+
+	return nil
+}
+
+//nextPage extracts the path from a registry pagination Link header such as </v2/_catalog?last=a&n=100>; rel="next"
+func nextPage(link string) string {
+	if link == "" {
+		return ""
+	}
+
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+	if start == -1 || end <= start {
+		return ""
+	}
+
+	u, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+
+	return u.RequestURI()
+}
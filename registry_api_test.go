@@ -0,0 +1,27 @@
+package kubby
+
+import "testing"
+
+func TestNextPage(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{"no header", "", ""},
+		{"catalog", `</v2/_catalog?last=a&n=100>; rel="next"`, "/v2/_catalog?last=a&n=100"},
+		{"tags", `</v2/team/app/tags/list?last=v1&n=50>; rel="next"`, "/v2/team/app/tags/list?last=v1&n=50"},
+		{"absolute url", `<http://localhost:5000/v2/_catalog?last=b&n=100>; rel="next"`, "/v2/_catalog?last=b&n=100"},
+		{"missing brackets", `/v2/_catalog?last=a; rel="next"`, ""},
+		{"reversed brackets", `>/v2/_catalog<`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := nextPage(test.link)
+			if got != test.want {
+				t.Errorf("nextPage(%q) = %q, want %q", test.link, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package kubby
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+const registryStoragePath = "/var/lib/registry"
+
+//RegistryConfig is translated into REGISTRY_* environment overrides for the registry container
+type RegistryConfig struct {
+	DeleteEnabled         bool
+	ReadOnly              bool
+	UploadPurging         bool
+	UploadPurgingAge      time.Duration
+	UploadPurgingInterval time.Duration
+	ProxyRemoteURL        string
+	ProxyUsername         string
+	ProxyPassword         string
+	Env                   map[string]string
+}
+
+//WithDeleteEnabled allows manifests to be deleted through the registry API
+func WithDeleteEnabled() RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Config.DeleteEnabled = true
+	}
+}
+
+//WithReadOnly rejects pushes, useful for a populated registry shared between runs
+func WithReadOnly() RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Config.ReadOnly = true
+	}
+}
+
+//WithUploadPurging removes abandoned uploads older than age, checking every interval
+func WithUploadPurging(age time.Duration, interval time.Duration) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Config.UploadPurging = true
+		r.Config.UploadPurgingAge = age
+		r.Config.UploadPurgingInterval = interval
+	}
+}
+
+//WithProxy runs the registry as a pull through cache of remoteURL. A proxying registry rejects pushes
+func WithProxy(remoteURL string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Config.ProxyRemoteURL = remoteURL
+	}
+}
+
+//WithProxyAuth sets the credentials a proxying registry uses to pull from its upstream
+func WithProxyAuth(username string, password string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Config.ProxyUsername = username
+		r.Config.ProxyPassword = password
+	}
+}
+
+//WithRegistryPullPolicy sets when the registry image is pulled, only when it isn't present locally by default
+func WithRegistryPullPolicy(policy PullPolicy) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Pull = policy
+	}
+}
+
+//WithRegistryEnv sets any registry configuration value using its environment form, e.g. REGISTRY_LOG_LEVEL
+func WithRegistryEnv(key string, value string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Config.Env[key] = value
+	}
+}
+
+//WithStorageVolume stores images in a named docker volume so they survive the registry container being deleted
+func WithStorageVolume(volume string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Mounts = append(r.Mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: volume,
+			Target: registryStoragePath,
+		})
+	}
+}
+
+//WithStoragePath stores images in a directory on the host so they survive the registry container being deleted
+func WithStoragePath(path string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.Mounts = append(r.Mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: path,
+			Target: registryStoragePath,
+		})
+	}
+}
+
+func (config *RegistryConfig) EnvVars() []string {
+	env := []string{}
+
+	if config.DeleteEnabled {
+		env = append(env, "REGISTRY_STORAGE_DELETE_ENABLED=true")
+	}
+
+	if config.ReadOnly {
+		env = append(env, "REGISTRY_STORAGE_MAINTENANCE_READONLY_ENABLED=true")
+	}
+
+	if config.UploadPurging {
+		env = append(env,
+			"REGISTRY_STORAGE_MAINTENANCE_UPLOADPURGING_ENABLED=true",
+			fmt.Sprintf("REGISTRY_STORAGE_MAINTENANCE_UPLOADPURGING_AGE=%s", config.UploadPurgingAge),
+			fmt.Sprintf("REGISTRY_STORAGE_MAINTENANCE_UPLOADPURGING_INTERVAL=%s", config.UploadPurgingInterval),
+			"REGISTRY_STORAGE_MAINTENANCE_UPLOADPURGING_DRYRUN=false",
+		)
+	}
+
+	if config.ProxyRemoteURL != "" {
+		env = append(env, fmt.Sprintf("REGISTRY_PROXY_REMOTEURL=%s", config.ProxyRemoteURL))
+	}
+
+	if config.ProxyUsername != "" {
+		env = append(env,
+			fmt.Sprintf("REGISTRY_PROXY_USERNAME=%s", config.ProxyUsername),
+			fmt.Sprintf("REGISTRY_PROXY_PASSWORD=%s", config.ProxyPassword),
+		)
+	}
+
+	for k, v := range config.Env {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return env
+}
+
+//prepareStorage makes sure bind mounted storage directories exist, docker would otherwise create them as root
+func prepareStorage(mounts []mount.Mount) error {
+	for i, m := range mounts {
+		if m.Type != mount.TypeBind {
+			continue
+		}
+
+		path, err := filepath.Abs(m.Source)
+		if err != nil {
+			return fmt.Errorf("prepareStorage: %w", err)
+		}
+
+		err = os.MkdirAll(path, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("prepareStorage: %w", err)
+		}
+
+		mounts[i].Source = path
+	}
+
+	return nil
+}
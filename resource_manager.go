@@ -12,32 +12,30 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
 	v1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 type KubeResourceManager struct {
-	Client kubernetes.Clientset
+	*KubeClients
 }
 
 func NewKubeResourceManager(kubePath string) (*KubeResourceManager, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubePath)
+	clients, err := NewKubeClientsFromPath(kubePath)
 	if err != nil {
 		return nil, fmt.Errorf("NewKubeResourceManager: %w", err)
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("NewKubeResourceManager: %w", err)
-	}
+	return NewKubeResourceManagerForClients(clients), nil
+}
 
+//NewKubeResourceManagerForClients creates a manager that shares clients with its caller
+func NewKubeResourceManagerForClients(clients *KubeClients) *KubeResourceManager {
 	manager := &KubeResourceManager{
-		Client: *clientset,
+		KubeClients: clients,
 	}
 
-	return manager, nil
+	return manager
 }
 
 func (manager *KubeResourceManager) RunJob(ctx context.Context, namespace string, jobSpec *batchv1.Job, checkInterval time.Duration) error {
@@ -89,21 +87,6 @@ func (manager *KubeResourceManager) RunJob(ctx context.Context, namespace string
 	return nil
 }
 
-func (manager *KubeResourceManager) CreateNamespace(ctx context.Context, name string) error {
-	fmt.Printf("creating namespace %s ...\n", name)
-
-	_, err := manager.Client.CoreV1().Namespaces().Create(ctx, &apiv1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-		},
-	}, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("CreateNamespace: %w", err)
-	}
-
-	return nil
-}
-
 func (manager *KubeResourceManager) CreateDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) error {
 	client := manager.Client.AppsV1().Deployments(namespace)
 
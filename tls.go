@@ -0,0 +1,287 @@
+package kubby
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+const (
+	registryCAFile   = "ca.crt"
+	registryCertFile = "registry.crt"
+	registryKeyFile  = "registry.key"
+	registryCertPath = "/certs"
+)
+
+//WithTLS serves the registry over https using a CA and server certificate kept in certDir. They are generated on
+//first use and reused afterwards so kind nodes and other clusters keep trusting the registry
+func WithTLS(certDir string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.CertDir = certDir
+	}
+}
+
+//WithDockerCertsDir sets the docker certs.d directory the registry CA is installed into. The default is the
+//directory dockerd reads on Linux, /etc/docker/certs.d, which usually needs root to write, and ~/.docker/certs.d
+//read by Docker Desktop elsewhere. Rootless dockerd reads ~/.config/docker/certs.d instead
+func WithDockerCertsDir(dir string) RegistryOption {
+	return func(r *ClusterRegistry) {
+		r.DockerCertsDir = dir
+	}
+}
+
+//GenerateRegistryCerts writes a self signed CA and a server certificate for hosts into dir. Existing certificates
+//are kept while they are unexpired and cover every host
+func GenerateRegistryCerts(dir string, hosts ...string) error {
+	if len(hosts) == 0 {
+		return fmt.Errorf("GenerateRegistryCerts: %w", &MissingFieldError{
+			field: "hosts",
+		})
+	}
+
+	valid, err := registryCertValid(dir, hosts)
+	if err != nil {
+		return fmt.Errorf("GenerateRegistryCerts: %w", err)
+	}
+
+	if valid {
+		return nil
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("GenerateRegistryCerts: %w", err)
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("GenerateRegistryCerts: %w", err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubby registry CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("GenerateRegistryCerts: %w", err)
+	}
+
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return fmt.Errorf("GenerateRegistryCerts: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("GenerateRegistryCerts: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("GenerateRegistryCerts: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("GenerateRegistryCerts: %w", err)
+	}
+
+	files := map[string]*pem.Block{
+		registryCAFile:   {Type: "CERTIFICATE", Bytes: caDER},
+		registryCertFile: {Type: "CERTIFICATE", Bytes: certDER},
+		registryKeyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+
+	for name, block := range files {
+		mode := os.FileMode(0644)
+		if name == registryKeyFile {
+			mode = 0600
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), mode)
+		if err != nil {
+			return fmt.Errorf("GenerateRegistryCerts: %w", err)
+		}
+
+		//WriteFile keeps the mode of a file that already exists, e.g. a key written by an older version
+		err = os.Chmod(filepath.Join(dir, name), mode)
+		if err != nil {
+			return fmt.Errorf("GenerateRegistryCerts: %w", err)
+		}
+	}
+
+	return nil
+}
+
+//registryCertValid reports whether dir already holds a CA, an unexpired server certificate signed by it and valid
+//for every host, and the key matching that certificate
+func registryCertValid(dir string, hosts []string) (bool, error) {
+	files := map[string][]byte{}
+	for _, name := range []string{registryCAFile, registryCertFile, registryKeyFile} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+
+			return false, fmt.Errorf("registryCertValid: %w", err)
+		}
+
+		files[name] = content
+	}
+
+	//X509KeyPair fails unless the key belongs to the certificate
+	pair, err := tls.X509KeyPair(files[registryCertFile], files[registryKeyFile])
+	if err != nil {
+		return false, nil
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false, nil
+	}
+
+	block, _ := pem.Decode(files[registryCAFile])
+	if block == nil {
+		return false, nil
+	}
+
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false, nil
+	}
+
+	if cert.CheckSignatureFrom(ca) != nil {
+		return false, nil
+	}
+
+	if time.Now().After(cert.NotAfter) || time.Now().After(ca.NotAfter) {
+		return false, nil
+	}
+
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+//enableTLS generates the registry's certificates, mounts them into the container, trusts the CA for kubby's own
+//requests and installs it into the docker certs directory for pushes from the host
+func (r *ClusterRegistry) enableTLS() error {
+	certDir, err := filepath.Abs(r.CertDir)
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.enableTLS: %w", err)
+	}
+
+	err = GenerateRegistryCerts(certDir, r.Name, "localhost", "127.0.0.1")
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.enableTLS: %w", err)
+	}
+
+	r.Mounts = append(r.Mounts, mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   certDir,
+		Target:   registryCertPath,
+		ReadOnly: true,
+	})
+	r.Env = append(r.Env,
+		fmt.Sprintf("REGISTRY_HTTP_TLS_CERTIFICATE=%s/%s", registryCertPath, registryCertFile),
+		fmt.Sprintf("REGISTRY_HTTP_TLS_KEY=%s/%s", registryCertPath, registryKeyFile),
+	)
+
+	caPEM, err := ioutil.ReadFile(filepath.Join(certDir, registryCAFile))
+	if err != nil {
+		return fmt.Errorf("ClusterRegistry.enableTLS: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+
+	r.Scheme = "https"
+	r.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	dockerCertsDir := r.DockerCertsDir
+	if dockerCertsDir == "" {
+		dockerCertsDir, err = defaultDockerCertsDir()
+		if err != nil {
+			return fmt.Errorf("ClusterRegistry.enableTLS: %w", err)
+		}
+	}
+
+	for _, host := range []string{r.Url, r.ClusterUrl} {
+		dir := filepath.Join(dockerCertsDir, host)
+
+		err = os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("ClusterRegistry.enableTLS: %w", err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, registryCAFile), caPEM, 0644)
+		if err != nil {
+			return fmt.Errorf("ClusterRegistry.enableTLS: %w", err)
+		}
+	}
+
+	return nil
+}
+
+//defaultDockerCertsDir is the certs.d directory the local daemon reads registry CAs from
+func defaultDockerCertsDir() (string, error) {
+	if runtime.GOOS == "linux" {
+		return "/etc/docker/certs.d", nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("defaultDockerCertsDir: %w", err)
+	}
+
+	return filepath.Join(home, ".docker", "certs.d"), nil
+}
@@ -0,0 +1,175 @@
+package kubby
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistryCertValid(t *testing.T) {
+	hosts := []string{"kind-registry", "localhost", "127.0.0.1"}
+
+	tests := []struct {
+		name   string
+		hosts  []string
+		modify func(t *testing.T, dir string, other string)
+		want   bool
+	}{
+		{
+			name:  "generated certificates",
+			hosts: hosts,
+			want:  true,
+		},
+		{
+			name:  "subset of hosts",
+			hosts: []string{"localhost"},
+			want:  true,
+		},
+		{
+			name:  "host not covered",
+			hosts: append(hosts, "registry.example.com"),
+		},
+		{
+			name:  "missing CA",
+			hosts: hosts,
+			modify: func(t *testing.T, dir string, other string) {
+				removeTestFile(t, filepath.Join(dir, registryCAFile))
+			},
+		},
+		{
+			name:  "missing key",
+			hosts: hosts,
+			modify: func(t *testing.T, dir string, other string) {
+				removeTestFile(t, filepath.Join(dir, registryKeyFile))
+			},
+		},
+		{
+			name:  "key from another pair",
+			hosts: hosts,
+			modify: func(t *testing.T, dir string, other string) {
+				copyTestFile(t, filepath.Join(other, registryKeyFile), filepath.Join(dir, registryKeyFile))
+			},
+		},
+		{
+			name:  "certificate not signed by the CA",
+			hosts: hosts,
+			modify: func(t *testing.T, dir string, other string) {
+				copyTestFile(t, filepath.Join(other, registryCAFile), filepath.Join(dir, registryCAFile))
+			},
+		},
+		{
+			name:  "corrupt certificate",
+			hosts: hosts,
+			modify: func(t *testing.T, dir string, other string) {
+				writeTestFile(t, filepath.Join(dir, registryCertFile), []byte("not a certificate"))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "kubby-certs-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			other, err := ioutil.TempDir("", "kubby-certs-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(other)
+
+			for _, d := range []string{dir, other} {
+				err = GenerateRegistryCerts(d, hosts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.modify != nil {
+				test.modify(t, dir, other)
+			}
+
+			got, err := registryCertValid(dir, test.hosts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.want {
+				t.Errorf("registryCertValid = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGenerateRegistryCertsKeepsValidCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubby-certs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = GenerateRegistryCerts(dir, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ioutil.ReadFile(filepath.Join(dir, registryCAFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = GenerateRegistryCerts(dir, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := ioutil.ReadFile(filepath.Join(dir, registryCAFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(before) != string(after) {
+		t.Error("the CA was regenerated although the existing certificates were valid")
+	}
+
+	info, err := os.Stat(filepath.Join(dir, registryKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key mode is %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func removeTestFile(t *testing.T, path string) {
+	t.Helper()
+
+	err := os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyTestFile(t *testing.T, src string, dst string) {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, dst, content)
+}
+
+func writeTestFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+
+	err := ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}